@@ -0,0 +1,304 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhook 告警通知地址
+type Webhook struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+type Options struct {
+	Webhooks []Webhook
+	Rules    []Rule
+	Cooldown time.Duration // 同一设备同一规则两次通知的最小间隔
+	Retry    int           // 发送失败后的重试次数
+	Timeout  time.Duration // 单次发送超时时间
+}
+
+// Notification 发送到webhook的告警内容
+type Notification struct {
+	Rule              string    `json:"rule"`
+	Type              string    `json:"type"`
+	DeviceID          uint      `json:"device_id"`
+	MaterialID        uint      `json:"material_id"`
+	MaterialVersionID uint      `json:"material_version_id"`
+	PointName         string    `json:"point_name,omitempty"`
+	Message           string    `json:"message"`
+	Value             float64   `json:"value"`
+	Threshold         float64   `json:"threshold"`
+	Time              time.Time `json:"time"`
+}
+
+type delivery struct {
+	webhook      Webhook
+	notification Notification
+}
+
+// queueSize 待发送通知的队列长度，队列满时丢弃新的通知
+const queueSize = 100
+
+// Alerter 告警规则评估及webhook通知
+// 产品写入后评估良率、连续不合格、条码失败率规则，并转发控制图违规事件，
+// 通知在后台异步发送，失败时重试，同一设备同一规则在冷却时间内只通知一次
+type Alerter struct {
+	options Options
+	client  *http.Client
+
+	mu      sync.Mutex
+	devices map[uint]*deviceState
+	fired   map[string]time.Time // 冷却key -> 上次通知时间
+
+	queueMu  sync.Mutex // 保证停止后不再有通知进入队列
+	stopOnce sync.Once
+	queue    chan delivery
+	stopping chan struct{}   // 调用 Stop 后关闭，不再接收新的通知，也不再重试发送失败的通知
+	ctx      context.Context // 超过停止期限后取消，中断发送中的请求并丢弃剩余的通知
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewAlerter(options Options) (*Alerter, error) {
+	var webhooks = make(map[string]bool)
+	for _, webhook := range options.Webhooks {
+		webhooks[webhook.Name] = true
+	}
+	for i := range options.Rules {
+		rule := &options.Rules[i]
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		for _, name := range rule.Webhooks {
+			if !webhooks[name] {
+				return nil, fmt.Errorf("alert rule %s refers to unknown webhook %s", rule.Name, name)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Alerter{
+		options:  options,
+		client:   &http.Client{Timeout: options.Timeout},
+		devices:  make(map[uint]*deviceState),
+		fired:    make(map[string]time.Time),
+		queue:    make(chan delivery, queueSize),
+		stopping: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// windowCapacity 设备需要保留的最近产品数量
+func (a *Alerter) windowCapacity() int {
+	var capacity = 1
+	for _, rule := range a.options.Rules {
+		if rule.Window > capacity {
+			capacity = rule.Window
+		}
+	}
+	return capacity
+}
+
+// AfterProduce 累加设备的产品判定结果并评估告警规则
+func (a *Alerter) AfterProduce(product *orm.Product, specs []orm.PointSpec) {
+	var deliveries []delivery
+	a.mu.Lock()
+	state, ok := a.devices[product.DeviceID]
+	if !ok {
+		state = &deviceState{windowCapacity: a.windowCapacity()}
+		a.devices[product.DeviceID] = state
+	}
+	state.push(product)
+	for i := range a.options.Rules {
+		rule := &a.options.Rules[i]
+		message, value, fired := state.evaluate(rule)
+		if !fired || !a.cooldown(fmt.Sprintf("%s_%v", rule.Name, product.DeviceID)) {
+			continue
+		}
+		deliveries = append(deliveries, a.deliveries(rule, Notification{
+			Rule:              rule.Name,
+			Type:              rule.Type,
+			DeviceID:          product.DeviceID,
+			MaterialID:        product.MaterialID,
+			MaterialVersionID: product.MaterialVersionID,
+			Message:           message,
+			Value:             value,
+			Threshold:         rule.Threshold,
+			Time:              clock.Now(),
+		})...)
+	}
+	a.mu.Unlock()
+
+	a.enqueue(deliveries)
+}
+
+// OnViolation 转发控制图违规事件，同一设备点位的同一判异规则共享冷却时间
+func (a *Alerter) OnViolation(violation *orm.ControlViolation) {
+	var deliveries []delivery
+	a.mu.Lock()
+	for i := range a.options.Rules {
+		rule := &a.options.Rules[i]
+		if rule.Type != RuleTypeControlViolation {
+			continue
+		}
+		key := fmt.Sprintf("%s_%v_%s_%v", rule.Name, violation.DeviceID, violation.PointName, violation.Rule)
+		if !a.cooldown(key) {
+			continue
+		}
+		deliveries = append(deliveries, a.deliveries(rule, Notification{
+			Rule:              rule.Name,
+			Type:              rule.Type,
+			DeviceID:          violation.DeviceID,
+			MaterialVersionID: violation.MaterialVersionID,
+			PointName:         violation.PointName,
+			Message:           violation.Description,
+			Value:             violation.Value,
+			Time:              violation.CreatedAt,
+		})...)
+	}
+	a.mu.Unlock()
+
+	a.enqueue(deliveries)
+}
+
+// cooldown 判断key是否已过冷却时间，是则记录本次通知时间，调用时需持有锁
+func (a *Alerter) cooldown(key string) bool {
+	now := time.Now()
+	if last, ok := a.fired[key]; ok && now.Sub(last) < a.options.Cooldown {
+		return false
+	}
+	a.fired[key] = now
+	return true
+}
+
+// deliveries 生成规则需要通知的webhook发送任务
+func (a *Alerter) deliveries(rule *Rule, notification Notification) []delivery {
+	var deliveries []delivery
+	for _, webhook := range a.options.Webhooks {
+		if len(rule.Webhooks) > 0 && !contains(rule.Webhooks, webhook.Name) {
+			continue
+		}
+		deliveries = append(deliveries, delivery{webhook: webhook, notification: notification})
+	}
+	return deliveries
+}
+
+// enqueue 将发送任务放入队列，已停止时丢弃
+func (a *Alerter) enqueue(deliveries []delivery) {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	for _, d := range deliveries {
+		select {
+		case <-a.stopping:
+			log.Error("alerter stopped, drop notification of rule %s to %s", d.notification.Rule, d.webhook.Name)
+			continue
+		default:
+		}
+		select {
+		case a.queue <- d:
+		default:
+			log.Error("alert queue is full, drop notification of rule %s to %s", d.notification.Rule, d.webhook.Name)
+		}
+	}
+}
+
+// Start 启动后台发送
+func (a *Alerter) Start() {
+	go func() {
+		defer close(a.done)
+		for d := range a.queue {
+			if a.ctx.Err() != nil {
+				log.Error("alerter stopped, drop notification of rule %s to %s", d.notification.Rule, d.webhook.Name)
+				continue
+			}
+			a.send(d)
+		}
+	}()
+}
+
+// Stop 停止接收新的通知，不再重试发送失败的通知，并在 timeout 内等待队列中的通知发送完成，
+// 超时后中断发送中的请求并丢弃剩余的通知，可以重复调用
+func (a *Alerter) Stop(timeout time.Duration) {
+	a.stopOnce.Do(func() {
+		a.queueMu.Lock()
+		close(a.stopping)
+		close(a.queue)
+		a.queueMu.Unlock()
+	})
+	defer a.cancel()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-a.done:
+	case <-timer.C:
+		a.cancel()
+		<-a.done
+	}
+}
+
+// send 发送通知，失败时按1s、2s、4s...间隔重试，停止后不再重试
+func (a *Alerter) send(d delivery) {
+	body, err := json.Marshal(d.notification)
+	if err != nil {
+		log.Error("marshal alert notification failed: %v", err)
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = a.post(d.webhook.URL, body)
+		if err == nil {
+			return
+		}
+		if attempt >= a.options.Retry {
+			log.Error("send alert of rule %s to %s failed: %v", d.notification.Rule, d.webhook.Name, err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-a.stopping:
+			log.Error("send alert of rule %s to %s failed, no retry after stop: %v", d.notification.Rule, d.webhook.Name, err)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (a *Alerter) post(url string, body []byte) error {
+	req, err := http.NewRequestWithContext(a.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook responded with status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+func contains(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
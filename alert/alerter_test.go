@@ -0,0 +1,108 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+// startTestWebhook 启动记录收到的通知的webhook
+func startTestWebhook(t *testing.T) (*httptest.Server, func() []Notification) {
+	t.Helper()
+	var mu sync.Mutex
+	var received []Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, n)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []Notification {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Notification(nil), received...)
+	}
+}
+
+func TestAlerterNotify(t *testing.T) {
+	server, received := startTestWebhook(t)
+	alerter, err := NewAlerter(Options{
+		Webhooks: []Webhook{{Name: "test", URL: server.URL}},
+		Rules: []Rule{
+			{Name: "ng", Type: RuleTypeConsecutiveNG, Count: 2},
+			{Name: "spc", Type: RuleTypeControlViolation},
+		},
+		Cooldown: time.Hour,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerter.Start()
+
+	// 冷却时间内同一设备同一规则只通知一次，不同设备分别通知
+	for i := 0; i < 4; i++ {
+		alerter.AfterProduce(&orm.Product{DeviceID: 1, MaterialVersionID: 1}, nil)
+	}
+	alerter.AfterProduce(&orm.Product{DeviceID: 2}, nil)
+	alerter.AfterProduce(&orm.Product{DeviceID: 2}, nil)
+	violation := &orm.ControlViolation{DeviceID: 1, PointName: "P1", Rule: 1, Description: "1 point beyond 3 sigma"}
+	alerter.OnViolation(violation)
+	alerter.OnViolation(violation)
+	alerter.Stop(time.Second)
+
+	notifications := received()
+	if len(notifications) != 3 {
+		t.Fatalf("received %v notifications, want 3: %+v", len(notifications), notifications)
+	}
+	var rules = make(map[string]int)
+	for _, n := range notifications {
+		rules[n.Rule]++
+	}
+	if rules["ng"] != 2 || rules["spc"] != 1 {
+		t.Errorf("notifications by rule = %v, want ng 2 spc 1", rules)
+	}
+}
+
+func TestAlerterStopWhileEnqueue(t *testing.T) {
+	server, _ := startTestWebhook(t)
+	alerter, err := NewAlerter(Options{
+		Webhooks: []Webhook{{Name: "test", URL: server.URL}},
+		Rules: []Rule{
+			{Name: "ng", Type: RuleTypeConsecutiveNG, Count: 1},
+			{Name: "spc", Type: RuleTypeControlViolation},
+		},
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerter.Start()
+
+	// 停止时仍有产品写入及违规事件，停止后的通知被丢弃而不是向已关闭的队列发送
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(deviceID uint) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				alerter.AfterProduce(&orm.Product{DeviceID: deviceID}, nil)
+				alerter.OnViolation(&orm.ControlViolation{DeviceID: deviceID, Rule: j})
+			}
+		}(uint(i))
+	}
+	time.Sleep(5 * time.Millisecond)
+	alerter.Stop(time.Second)
+	alerter.Stop(time.Second)
+	wg.Wait()
+}
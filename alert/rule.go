@@ -0,0 +1,106 @@
+package alert
+
+import (
+	"fmt"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+// 告警规则类型
+const (
+	RuleTypeYield            = "yield"             // 最近 Window 个产品的良率低于 Threshold
+	RuleTypeConsecutiveNG    = "consecutive_ng"    // 连续 Count 个产品不合格
+	RuleTypeBarCodeFailure   = "barcode_failure"   // 最近 Window 个产品的条码解析失败率高于 Threshold
+	RuleTypeControlViolation = "control_violation" // 控制图判异规则违规
+)
+
+// Rule 告警规则
+// Threshold 为比例，例如 0.9 表示 90%；Webhooks 为空时通知全部webhook
+type Rule struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Window    int      `yaml:"window"`
+	Threshold float64  `yaml:"threshold"`
+	Count     int      `yaml:"count"`
+	Webhooks  []string `yaml:"webhooks"`
+}
+
+func (r *Rule) validate() error {
+	switch r.Type {
+	case RuleTypeYield, RuleTypeBarCodeFailure:
+		if r.Window <= 0 {
+			return fmt.Errorf("alert rule %s requires window > 0", r.Name)
+		}
+	case RuleTypeConsecutiveNG:
+		if r.Count <= 0 {
+			return fmt.Errorf("alert rule %s requires count > 0", r.Name)
+		}
+	case RuleTypeControlViolation:
+	default:
+		return fmt.Errorf("alert rule %s has unknown type %s", r.Name, r.Type)
+	}
+	return nil
+}
+
+// deviceState 设备最近产品的判定结果，用于评估告警规则
+type deviceState struct {
+	qualified      []bool // 最近产品是否合格，按写入顺序
+	barCodeOK      []bool // 最近产品条码是否解析成功
+	consecutiveNG  int
+	windowCapacity int
+}
+
+func (s *deviceState) push(product *orm.Product) {
+	s.qualified = appendLimited(s.qualified, product.Qualified, s.windowCapacity)
+	s.barCodeOK = appendLimited(s.barCodeOK, product.BarCodeStatus == orm.BarCodeStatusSuccess, s.windowCapacity)
+	if product.Qualified {
+		s.consecutiveNG = 0
+	} else {
+		s.consecutiveNG++
+	}
+}
+
+// evaluate 评估规则，触发时返回告警描述及当前值
+func (s *deviceState) evaluate(rule *Rule) (message string, value float64, fired bool) {
+	switch rule.Type {
+	case RuleTypeYield:
+		if len(s.qualified) < rule.Window {
+			return
+		}
+		value = ratioOf(s.qualified[len(s.qualified)-rule.Window:], true)
+		if value < rule.Threshold {
+			return fmt.Sprintf("yield of last %v parts is %.2f%%, below %.2f%%", rule.Window, value*100, rule.Threshold*100), value, true
+		}
+	case RuleTypeBarCodeFailure:
+		if len(s.barCodeOK) < rule.Window {
+			return
+		}
+		value = ratioOf(s.barCodeOK[len(s.barCodeOK)-rule.Window:], false)
+		if value > rule.Threshold {
+			return fmt.Sprintf("barcode failure rate of last %v parts is %.2f%%, above %.2f%%", rule.Window, value*100, rule.Threshold*100), value, true
+		}
+	case RuleTypeConsecutiveNG:
+		value = float64(s.consecutiveNG)
+		if s.consecutiveNG >= rule.Count {
+			return fmt.Sprintf("%v consecutive unqualified parts", s.consecutiveNG), value, true
+		}
+	}
+	return
+}
+
+func appendLimited(values []bool, value bool, limit int) []bool {
+	values = append(values, value)
+	if len(values) > limit {
+		values = values[len(values)-limit:]
+	}
+	return values
+}
+
+func ratioOf(values []bool, target bool) float64 {
+	var count int
+	for _, v := range values {
+		if v == target {
+			count++
+		}
+	}
+	return float64(count) / float64(len(values))
+}
@@ -0,0 +1,64 @@
+package alert
+
+import (
+	"testing"
+
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+func TestRuleValidate(t *testing.T) {
+	var cases = []struct {
+		rule Rule
+		ok   bool
+	}{
+		{Rule{Name: "yield", Type: RuleTypeYield, Window: 10, Threshold: 0.9}, true},
+		{Rule{Name: "yield", Type: RuleTypeYield}, false},
+		{Rule{Name: "barcode", Type: RuleTypeBarCodeFailure, Window: 10}, true},
+		{Rule{Name: "barcode", Type: RuleTypeBarCodeFailure}, false},
+		{Rule{Name: "ng", Type: RuleTypeConsecutiveNG, Count: 3}, true},
+		{Rule{Name: "ng", Type: RuleTypeConsecutiveNG}, false},
+		{Rule{Name: "spc", Type: RuleTypeControlViolation}, true},
+		{Rule{Name: "unknown", Type: "unknown"}, false},
+	}
+	for _, c := range cases {
+		if err := c.rule.validate(); (err == nil) != c.ok {
+			t.Errorf("validate %+v: err = %v, want ok %v", c.rule, err, c.ok)
+		}
+	}
+}
+
+func TestDeviceStateEvaluate(t *testing.T) {
+	// q 表示合格，n 表示不合格；b 表示条码解析失败的合格产品
+	var cases = []struct {
+		name     string
+		rule     Rule
+		products string
+		fired    bool
+		value    float64
+	}{
+		{"yield window not full", Rule{Type: RuleTypeYield, Window: 4, Threshold: 0.9}, "nnn", false, 0},
+		{"yield below threshold", Rule{Type: RuleTypeYield, Window: 4, Threshold: 0.9}, "qqqn", true, 0.75},
+		{"yield at threshold", Rule{Type: RuleTypeYield, Window: 4, Threshold: 0.75}, "qqqn", false, 0.75},
+		{"yield uses last window", Rule{Type: RuleTypeYield, Window: 4, Threshold: 0.9}, "nnqqqq", false, 1},
+		{"barcode failure above threshold", Rule{Type: RuleTypeBarCodeFailure, Window: 4, Threshold: 0.2}, "qbqb", true, 0.5},
+		{"barcode failure at threshold", Rule{Type: RuleTypeBarCodeFailure, Window: 4, Threshold: 0.25}, "qqqb", false, 0.25},
+		{"barcode failure window not full", Rule{Type: RuleTypeBarCodeFailure, Window: 4}, "bbb", false, 0},
+		{"consecutive ng", Rule{Type: RuleTypeConsecutiveNG, Count: 3}, "qnnn", true, 3},
+		{"consecutive ng reset", Rule{Type: RuleTypeConsecutiveNG, Count: 3}, "nnqn", false, 1},
+		{"control violation ignored", Rule{Type: RuleTypeControlViolation}, "nnnn", false, 0},
+	}
+	for _, c := range cases {
+		state := &deviceState{windowCapacity: 4}
+		for _, r := range c.products {
+			product := &orm.Product{Qualified: r != 'n', BarCodeStatus: orm.BarCodeStatusSuccess}
+			if r == 'b' {
+				product.BarCodeStatus = orm.BarCodeStatusReadFail
+			}
+			state.push(product)
+		}
+		_, value, fired := state.evaluate(&c.rule)
+		if fired != c.fired || value != c.value {
+			t.Errorf("%s: fired = %v value = %v, want %v %v", c.name, fired, value, c.fired, c.value)
+		}
+	}
+}
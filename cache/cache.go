@@ -3,37 +3,70 @@ package cache
 import (
 	"errors"
 	"fmt"
-	"github.com/SasukeBo/configer"
 	"github.com/astaxie/beego/cache"
+	"sync"
 	"time"
 )
 
 var (
+	mu          sync.RWMutex
 	globalCache cache.Cache
-	expiredTime = configer.GetInt("cache_expired_time")
+	expiredTime time.Duration
 )
 
-func init() {
-	var err error
-	globalCache, err = cache.NewCache("memory", `{"interval":60}`)
+// Options 缓存配置
+type Options struct {
+	ExpiredTime time.Duration // 单个数据的存活时间
+	GCInterval  time.Duration // 清理过期数据的间隔，0时为60秒
+}
+
+// Init 创建全局缓存，未初始化时 Get 总是未命中，Set 不缓存数据
+func Init(options Options) error {
+	interval := options.GCInterval
+	if interval <= 0 {
+		interval = 60 * time.Second
+	}
+	store, err := cache.NewCache("memory", fmt.Sprintf(`{"interval":%d}`, int(interval.Seconds())))
 	if err != nil {
-		panic(fmt.Sprintf("initial global cache failed: %v", err))
+		return fmt.Errorf("initial global cache failed: %v", err)
 	}
+
+	mu.Lock()
+	globalCache = store
+	expiredTime = options.ExpiredTime
+	mu.Unlock()
+	return nil
+}
+
+func getCache() cache.Cache {
+	mu.RLock()
+	defer mu.RUnlock()
+	return globalCache
 }
 
 // Set cache
 func Set(key string, value interface{}) error {
-	return globalCache.Put(key, value, time.Duration(expiredTime)*time.Second)
+	mu.RLock()
+	c, expired := globalCache, expiredTime
+	mu.RUnlock()
+	if c == nil {
+		return nil
+	}
+	return c.Put(key, value, expired)
 }
 
 // Get interface value
 func Get(key string) interface{} {
-	return globalCache.Get(key)
+	c := getCache()
+	if c == nil {
+		return nil
+	}
+	return c.Get(key)
 }
 
 // GetString string value
 func GetString(key string) (string, error) {
-	value := globalCache.Get(key)
+	value := Get(key)
 	str, ok := value.(string)
 	if !ok {
 		return "", errors.New("value is not a string")
@@ -44,7 +77,7 @@ func GetString(key string) (string, error) {
 
 // GetBool string value
 func GetBool(key string) (bool, error) {
-	value := globalCache.Get(key)
+	value := Get(key)
 	bo, ok := value.(bool)
 	if !ok {
 		return false, errors.New("value is not a bool")
@@ -55,5 +88,9 @@ func GetBool(key string) (bool, error) {
 
 // FlushCacheWithKey a key value from global cache
 func FlushCacheWithKey(key string) error {
-	return globalCache.Delete(key)
+	c := getCache()
+	if c == nil {
+		return nil
+	}
+	return c.Delete(key)
 }
@@ -0,0 +1,101 @@
+package clock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	// 内置时区数据，容器中没有时区文件时也可以加载工厂时区
+	_ "time/tzdata"
+)
+
+// Clock 时间来源，测试时可以替换为固定时间
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var (
+	mu       sync.RWMutex
+	current  Clock = systemClock{}
+	location       = time.Local
+)
+
+// Now 工厂时区的当前时间，日期、班次、条码日期等均以此为准
+func Now() time.Time {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current.Now().In(location)
+}
+
+// Location 工厂时区，未调用 SetTimezone 时为系统时区
+func Location() *time.Location {
+	mu.RLock()
+	defer mu.RUnlock()
+	return location
+}
+
+// Today 工厂时区当前日期的零点
+func Today() time.Time {
+	now := Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// SetTimezone 设置工厂时区，name 为 IANA 时区名称，例如 Asia/Shanghai，为空时使用系统时区
+func SetTimezone(name string) error {
+	loc := time.Local
+	if name != "" {
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return fmt.Errorf("load timezone %s failed: %v", name, err)
+		}
+	}
+
+	mu.Lock()
+	location = loc
+	mu.Unlock()
+	return nil
+}
+
+// Set 替换时间来源，传入nil时恢复为系统时间
+func Set(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	mu.Lock()
+	current = c
+	mu.Unlock()
+}
+
+// FixedClock 固定时间的时钟，只在调用 Set、Add 时变化
+type FixedClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func NewFixedClock(t time.Time) *FixedClock {
+	return &FixedClock{t: t}
+}
+
+func (c *FixedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *FixedClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.t = t
+	c.mu.Unlock()
+}
+
+func (c *FixedClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
@@ -0,0 +1,47 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFixedClock(t *testing.T) {
+	if err := SetTimezone("Asia/Shanghai"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTimezone("")
+
+	fixed := NewFixedClock(time.Date(2026, 10, 16, 17, 30, 0, 0, time.UTC))
+	Set(fixed)
+	defer Set(nil)
+
+	// UTC 17:30 为上海时间次日 01:30
+	now := Now()
+	if now.Location().String() != "Asia/Shanghai" || now.Day() != 17 || now.Hour() != 1 {
+		t.Errorf("now = %v, want 2026-10-17 01:30 +0800", now)
+	}
+	if today := Today(); !today.Equal(time.Date(2026, 10, 17, 0, 0, 0, 0, Location())) {
+		t.Errorf("today = %v, want 2026-10-17 00:00 +0800", today)
+	}
+
+	fixed.Add(time.Hour)
+	if got := Now(); got.Hour() != 2 {
+		t.Errorf("now after add = %v, want 02:30", got)
+	}
+
+	Set(nil)
+	if time.Since(Now()) > time.Minute {
+		t.Errorf("now after reset = %v, want system time", Now())
+	}
+}
+
+func TestSetTimezone(t *testing.T) {
+	defer SetTimezone("")
+
+	if err := SetTimezone("Invalid/Zone"); err == nil {
+		t.Error("set invalid timezone should fail")
+	}
+	if err := SetTimezone(""); err != nil || Location() != time.Local {
+		t.Errorf("empty timezone = (%v, %v), want system timezone", Location(), err)
+	}
+}
@@ -3,3 +3,77 @@ env: prod
 
 # 缓存持续时间，用于配置缓存中单个数据的存活时间，单位秒
 cache_expired_time: 10
+
+# 数据库类型，支持 mysql、postgres、sqlite3，可在环境配置中覆盖
+# sqlite3 以 db_name 作为数据库文件路径，不需要 db_host 等配置
+db_driver: mysql
+
+# 数据库连接池及连接重试配置，时间单位秒，0为不限制
+db_max_open_conns: 50
+db_max_idle_conns: 10
+db_conn_max_lifetime: 3600
+db_connect_timeout: 10
+db_retry_limit: 5
+db_retry_interval: 2
+
+# 收到退出信号后等待处理中请求完成的最长时间，单位秒
+shutdown_timeout: 10
+
+# MQTT 接入配置，mqtt_broker 为空时不启用
+# 主题中使用 + 通配设备token所在层级
+mqtt_broker: ""
+mqtt_client_id: pmes-data-producer
+mqtt_topic: pmes/+/produce
+mqtt_username: ""
+mqtt_password: ""
+
+# TCP 行协议接入配置，tcp_address 为空时不启用，例如 :9000；tcp_delimiter 不能包含 ; 和 :
+tcp_address: ""
+tcp_delimiter: "|"
+
+# SPC 统计配置，统计量写入数据库的间隔，单位秒；直方图在规格上下限之间的区间数量
+spc_flush_interval: 10
+spc_histogram_buckets: 10
+
+# 控制图判异配置，点位规格未配置控制限时，由不少于该数量的历史样本推算控制限
+control_chart_min_samples: 25
+
+# 告警配置，alert_rules 为空时不启用
+# alert_webhooks 通知地址列表，例如：
+#   - {name: line-lead, url: "http://example.com/hook"}
+# alert_rules 告警规则列表，threshold 为比例，webhooks 为空时通知全部地址，例如：
+#   - {name: low-yield, type: yield, window: 50, threshold: 0.9}
+#   - {name: ng-streak, type: consecutive_ng, count: 5, webhooks: [line-lead]}
+#   - {name: barcode-fail, type: barcode_failure, window: 100, threshold: 0.05}
+#   - {name: drift, type: control_violation}
+alert_webhooks: []
+alert_rules: []
+# 同一设备同一规则的通知冷却时间，单位秒
+alert_cooldown: 600
+# 发送失败的重试次数，及单次发送超时时间，单位秒
+alert_retry: 3
+alert_timeout: 5
+
+# 实时导入记录日切时间，格式为 HH:MM，每天在该时间关闭之前日期仍在导入中的实时导入记录
+rollover_cutover: "00:05"
+
+# 班次日历，实时导入记录按设备及班次分段，为空时整天为一个班次
+# 各班次首尾相接覆盖全天，end 不晚于 start 时表示跨越零点，例如：
+#   - {name: A, start: "08:00", end: "16:00"}
+#   - {name: B, start: "16:00", end: "00:00"}
+#   - {name: C, start: "00:00", end: "08:00"}
+shifts: []
+
+# 工厂时区，IANA 时区名称，日期、班次、条码日期及数据库连接均使用该时区，为空时使用系统时区
+timezone: "Asia/Shanghai"
+
+# 设备上传检测时间的允许偏差，单位秒：早于接收时间的最大间隔（离线缓存），及晚于接收时间的最大间隔（时钟偏差），未配置或为0时不限制
+measured_at_max_delay: 604800
+measured_at_max_ahead: 300
+
+# 产品缓冲写入，启用后产品先进入内存队列，按间隔（毫秒）或数量批量写入，提交后才回复设备，队列满时返回503
+# MySQL innodb_autoinc_lock_mode 为 2 时无法由 LastInsertId 推算多行写入的产品ID，同一事务内逐行写入
+product_buffer_enabled: false
+product_buffer_flush_interval: 200
+product_buffer_flush_size: 500
+product_buffer_queue_size: 10000
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// ControlViolations 查询控制图违规事件
+// 查询参数：material_version_id、device_id、point_name、begin、end、limit 均可选，limit 默认100
+func ControlViolations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query = orm.ControlViolationQuery{PointName: c.Query("point_name"), Limit: 100}
+		var err error
+		query.MaterialVersionID, err = parseQueryID(c.Query("material_version_id"))
+		if err == nil {
+			query.DeviceID, err = parseQueryID(c.Query("device_id"))
+		}
+		if err == nil && c.Query("limit") != "" {
+			query.Limit, err = strconv.Atoi(c.Query("limit"))
+		}
+		if err == nil {
+			query.Begin, err = parseQueryTime(c.Query("begin"))
+		}
+		if err == nil {
+			query.End, err = parseQueryTime(c.Query("end"))
+		}
+		if err != nil {
+			var response = Response{
+				Message: "对不起，查询参数不合法，请检查您的输入。Sorry, the search params are illegal, please check your input.",
+				Origin:  err.Error(),
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, response)
+			return
+		}
+
+		violations, err := orm.FindControlViolations(query)
+		if err != nil {
+			var response = Response{
+				Message: "查询控制图违规事件失败.",
+				Origin:  err.Error(),
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, response)
+			return
+		}
+		c.JSON(http.StatusOK, violations)
+	}
+}
@@ -2,20 +2,40 @@ package handler
 
 import (
 	"encoding/json"
-	"github.com/SasukeBo/pmes-data-producer/orm"
+	"errors"
+	"github.com/SasukeBo/pmes-data-producer/service"
 	"github.com/gin-gonic/gin"
 	"io/ioutil"
 	"net/http"
-	"strconv"
-	"strings"
 )
 
 type Form struct {
-	DeviceToken string `json:"device_token"`
-	PointValues string `json:"point_values"`
-	Attributes  string `json:"attributes"`
-	Qualified   int    `json:"qualified"`
-	BarCode     string `json:"bar_code"`
+	DeviceToken string      `json:"device_token"`
+	PointValues PointValues `json:"point_values"`
+	Attributes  string      `json:"attributes"`
+	Qualified   int         `json:"qualified"`
+	BarCode     string      `json:"bar_code"`
+	MessageID   string      `json:"message_id"`  // 可选，设备生成的消息ID，重传时保持不变
+	MeasuredAt  string      `json:"measured_at"` // 可选，设备检测时间，支持RFC3339、2006-01-02 15:04:05及Unix时间戳
+}
+
+// Input 转换为产品接入服务的输入
+func (f *Form) Input(ip string) (*service.ProduceInput, error) {
+	measuredAt, err := service.ParseMeasuredAt(f.MeasuredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &service.ProduceInput{
+		DeviceToken: f.DeviceToken,
+		IP:          ip,
+		BarCode:     f.BarCode,
+		Qualified:   f.Qualified == 1,
+		PointValues: f.PointValues,
+		Attributes:  f.Attributes,
+		MessageID:   f.MessageID,
+		MeasuredAt:  measuredAt,
+	}, nil
 }
 
 type Response struct {
@@ -23,11 +43,22 @@ type Response struct {
 	Origin  string `json:"originErr"`
 }
 
-func DeviceProduce() gin.HandlerFunc {
+// ProduceResponse 存在点位检测值解析错误时的响应，点位值存储为null
+type ProduceResponse struct {
+	Message     string       `json:"message"`
+	PointErrors []PointError `json:"point_errors"`
+}
+
+func DeviceProduce(s *service.ProductService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		body, _ := ioutil.ReadAll(c.Request.Body)
 		var form Form
-		if err := json.Unmarshal(body, &form); err != nil {
+		var input *service.ProduceInput
+		err := json.Unmarshal(body, &form)
+		if err == nil {
+			input, err = form.Input(c.Request.Header.Get("X-Real-IP"))
+		}
+		if err != nil {
 			var response = Response{
 				Message: "对不起，查询参数不合法，请检查您的输入。Sorry, the search params are illegal, please check your input.",
 				Origin:  err.Error(),
@@ -36,86 +67,35 @@ func DeviceProduce() gin.HandlerFunc {
 			return
 		}
 
-		deviceToken := form.DeviceToken
-		var device orm.Device
-		if err := device.GetWithToken(deviceToken); err != nil {
-			var response = Response{
-				Message: "对不起，查找设备失败.",
-				Origin:  err.Error(),
-			}
-			c.AbortWithStatusJSON(http.StatusNotFound, response)
+		result, err := s.Produce(input)
+		if err != nil {
+			abortWithServiceError(c, err)
 			return
 		}
-		ip := c.Request.Header.Get("X-Real-IP")
-		if device.IP != ip {
-			device.IP = ip
-			_ = orm.DB.Save(&device)
-		}
-
-		var record orm.ImportRecord
-		if err := record.GetDeviceRealtimeRecord(&device); err != nil {
-			var response = Response{
-				Message: "获取设备实时导入记录失败.",
-				Origin:  err.Error(),
-			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, response)
+		if len(result.PointErrors) > 0 {
+			c.JSON(http.StatusOK, ProduceResponse{Message: "ok", PointErrors: newPointErrors(result.PointErrors)})
 			return
 		}
+		c.JSON(http.StatusOK, "ok")
+	}
+}
 
-		qualifiedInt := form.Qualified
-		var qualified bool
-		if qualifiedInt == 1 {
-			qualified = true
-		}
-
-		//attributesStr := form.Attributes
-		var attribute orm.Map
-		var statusCode = 1
-
-		rule := device.GetCurrentTemplateDecodeRule()
-		barCode := strings.TrimSpace(form.BarCode)
-		if rule != nil {
-			decoder := orm.NewBarCodeDecoder(rule)
-			attribute, statusCode = decoder.Decode(barCode)
-		} else {
-			attribute = make(orm.Map)
-		}
-
-		pointValuesStr := form.PointValues
-		pointValues := make(orm.Map)
-		kValues := strings.Split(pointValuesStr, ";")
-		for _, item := range kValues {
-			sectors := strings.Split(item, ":")
-			if len(sectors) < 2 {
-				continue
-			}
-			value, err := strconv.ParseFloat(sectors[1], 64)
-			if err != nil {
-				value = 0
-			}
-			pointValues[sectors[0]] = value
-		}
+// abortWithServiceError 将产品接入服务的错误转换为HTTP响应
+func abortWithServiceError(c *gin.Context, err error) {
+	var serviceErr *service.Error
+	if !errors.As(err, &serviceErr) {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, Response{Message: "服务器内部错误.", Origin: err.Error()})
+		return
+	}
 
-		var product = orm.Product{
-			MaterialID:        device.MaterialID,
-			DeviceID:          device.ID,
-			Qualified:         qualified,
-			Attribute:         attribute,
-			PointValues:       pointValues,
-			ImportRecordID:    record.ID,
-			MaterialVersionID: record.MaterialVersionID,
-			BarCode:           barCode,
-			BarCodeStatus:     statusCode,
-		}
-		if err := orm.DB.Create(&product).Error; err != nil {
-			var response = Response{
-				Message: "保存产品信息失败.",
-				Origin:  err.Error(),
-			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, response)
-			return
-		}
-		record.Increase(1, 1, qualified)
-		c.JSON(http.StatusOK, "ok")
+	var status = http.StatusInternalServerError
+	switch serviceErr.Code {
+	case service.ErrorCodeDeviceNotFound:
+		status = http.StatusNotFound
+	case service.ErrorCodeInvalidInput:
+		status = http.StatusBadRequest
+	case service.ErrorCodeBusy:
+		status = http.StatusServiceUnavailable
 	}
+	c.AbortWithStatusJSON(status, Response{Message: serviceErr.Message, Origin: serviceErr.Origin.Error()})
 }
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/SasukeBo/pmes-data-producer/service"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+)
+
+type BatchForm struct {
+	DeviceToken string `json:"device_token"`
+	Forms       []Form `json:"forms"`
+}
+
+const (
+	BatchItemStatusAccepted = "accepted"
+	BatchItemStatusRejected = "rejected"
+)
+
+// BatchItemResult 批量上传中单条数据的处理结果
+type BatchItemResult struct {
+	Index         int          `json:"index"`
+	Status        string       `json:"status"`
+	Reason        string       `json:"reason,omitempty"`
+	BarCodeStatus int          `json:"bar_code_status,omitempty"`
+	Duplicate     bool         `json:"duplicate,omitempty"` // 消息ID已上传过，返回原结果
+	PointErrors   []PointError `json:"point_errors,omitempty"`
+}
+
+type BatchResponse struct {
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Results  []BatchItemResult `json:"results"`
+}
+
+// DeviceProduceBatch 设备批量上传生产数据
+// 同一批次的数据属于同一设备，产品在同一事务中写入，实时导入记录只累加一次
+func DeviceProduceBatch(s *service.ProductService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		var form BatchForm
+		if err := json.Unmarshal(body, &form); err != nil {
+			var response = Response{
+				Message: "对不起，查询参数不合法，请检查您的输入。Sorry, the search params are illegal, please check your input.",
+				Origin:  err.Error(),
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, response)
+			return
+		}
+
+		// 单条数据转换失败时只拒绝该条数据，indexes 记录提交给服务的数据在批次中的索引
+		ip := c.Request.Header.Get("X-Real-IP")
+		var input = service.ProduceBatchInput{DeviceToken: form.DeviceToken, IP: ip}
+		var response = BatchResponse{Results: make([]BatchItemResult, len(form.Forms))}
+		var indexes []int
+		for idx := range form.Forms {
+			item, err := form.Forms[idx].Input(ip)
+			if err != nil {
+				response.Results[idx] = BatchItemResult{Index: idx, Status: BatchItemStatusRejected, Reason: err.Error()}
+				response.Rejected++
+				continue
+			}
+			input.Items = append(input.Items, *item)
+			indexes = append(indexes, idx)
+		}
+
+		result, err := s.ProduceBatch(&input)
+		if err != nil {
+			abortWithServiceError(c, err)
+			return
+		}
+
+		response.Accepted += result.Accepted
+		response.Rejected += result.Rejected
+		for i, item := range result.Items {
+			idx := indexes[i]
+			var itemResult = BatchItemResult{
+				Index:         idx,
+				Status:        BatchItemStatusRejected,
+				Reason:        item.Reason,
+				BarCodeStatus: item.BarCodeStatus,
+				Duplicate:     item.Duplicate,
+				PointErrors:   newPointErrors(item.PointErrors),
+			}
+			if item.Accepted {
+				itemResult.Status = BatchItemStatusAccepted
+			}
+			response.Results[idx] = itemResult
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
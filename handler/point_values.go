@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/SasukeBo/pmes-data-producer/service"
+	"sort"
+	"strings"
+)
+
+// PointValues 上传的点位检测值，支持以下三种格式：
+// - 字符串 "P1:0.12;P2:3.4"
+// - 对象 {"P1": 0.12, "P2": "3.4"}
+// - 数组 [{"name": "P1", "value": 0.12, "unit": "mm"}]
+// 值为null或空字符串时视为缺失
+type PointValues []service.PointValue
+
+type pointValueItem struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+	Unit  string          `json:"unit"`
+}
+
+func (p *PointValues) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		*p = nil
+		return nil
+	}
+
+	switch data[0] {
+	case '"':
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*p = service.ParsePointValuesString(str)
+	case '{':
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		var names []string
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var values PointValues
+		for _, name := range names {
+			values = append(values, service.PointValue{Name: name, Value: rawPointValue(object[name])})
+		}
+		*p = values
+	case '[':
+		var items []pointValueItem
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		var values PointValues
+		for _, item := range items {
+			values = append(values, service.PointValue{
+				Name:  strings.TrimSpace(item.Name),
+				Value: rawPointValue(item.Value),
+				Unit:  item.Unit,
+			})
+		}
+		*p = values
+	default:
+		return errors.New("point_values should be a string, object or array")
+	}
+
+	return nil
+}
+
+// rawPointValue 将JSON值转换为原始字符串，字符串去除引号，null为空
+func rawPointValue(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return ""
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return strings.TrimSpace(str)
+	}
+	return string(raw)
+}
+
+// PointError 点位检测值解析错误
+type PointError struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func newPointErrors(errs []service.PointValueError) []PointError {
+	var pointErrors []PointError
+	for _, err := range errs {
+		pointErrors = append(pointErrors, PointError{Name: err.Name, Value: err.Value, Reason: err.Reason})
+	}
+	return pointErrors
+}
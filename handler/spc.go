@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"github.com/SasukeBo/pmes-data-producer/spc"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var queryTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// parseQueryTime 解析查询参数中的时间，为空时返回零值
+func parseQueryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range queryTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, clock.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %s", value)
+}
+
+// parseQueryID 解析查询参数中的ID，为空时返回0
+func parseQueryID(value string) (uint, error) {
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	return uint(id), err
+}
+
+// SPCStatistics 查询料号版本各点位的SPC统计结果
+// 查询参数：material_version_id 必填，device_id、point_name、begin、end 可选
+func SPCStatistics(engine *spc.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query orm.SPCStatQuery
+		versionID, err := strconv.Atoi(c.Query("material_version_id"))
+		if err == nil {
+			query.DeviceID, err = parseQueryID(c.Query("device_id"))
+		}
+		if err == nil {
+			query.Begin, err = parseQueryTime(c.Query("begin"))
+		}
+		if err == nil {
+			query.End, err = parseQueryTime(c.Query("end"))
+		}
+		if err != nil {
+			var response = Response{
+				Message: "对不起，查询参数不合法，请检查您的输入。Sorry, the search params are illegal, please check your input.",
+				Origin:  err.Error(),
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, response)
+			return
+		}
+		query.MaterialVersionID = uint(versionID)
+		query.PointName = c.Query("point_name")
+
+		results, err := engine.Query(query)
+		if err != nil {
+			var response = Response{
+				Message: "查询SPC统计结果失败.",
+				Origin:  err.Error(),
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, response)
+			return
+		}
+		c.JSON(http.StatusOK, results)
+	}
+}
@@ -0,0 +1,49 @@
+package listener
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+// openTestDB 在临时目录中创建 SQLite 数据库并执行全部迁移，时钟固定为 now
+func openTestDB(t *testing.T, now time.Time) {
+	t.Helper()
+	clock.Set(clock.NewFixedClock(now))
+	t.Cleanup(func() { clock.Set(nil) })
+
+	if _, err := orm.Open(orm.Options{Driver: orm.DialectSQLite, Name: filepath.Join(t.TempDir(), "test.db")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = orm.Close() })
+	if _, err := orm.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestDevice 创建实时设备及其料号的当前版本
+func createTestDevice(t *testing.T, uuid string) *orm.Device {
+	t.Helper()
+	version := orm.MaterialVersion{Version: "v1", MaterialID: 1, Active: true}
+	if err := orm.DB.Create(&version).Error; err != nil {
+		t.Fatal(err)
+	}
+	device := orm.Device{UUID: uuid, Name: "device", Remark: "device", MaterialID: 1, IsRealtime: true}
+	if err := orm.DB.Create(&device).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &device
+}
+
+// countProducts 设备以消息ID写入的产品数量
+func countProducts(t *testing.T, deviceID uint, messageID string) int {
+	t.Helper()
+	var count int
+	if err := orm.DB.Model(&orm.Product{}).Where("device_id = ? AND message_id = ?", deviceID, messageID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
@@ -0,0 +1,145 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/handler"
+	"github.com/SasukeBo/pmes-data-producer/service"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"strings"
+	"time"
+)
+
+// MQTTOptions MQTT接入配置
+type MQTTOptions struct {
+	Broker   string // 服务地址，例如：tcp://127.0.0.1:1883
+	ClientID string // 客户端ID，同一ID重连后可以收到离线期间的QoS1消息
+	Username string
+	Password string
+	Topic    string // 订阅主题，设备token所在层级使用+通配，例如：pmes/+/produce
+}
+
+// MQTTListener 订阅设备上传生产数据的MQTT主题
+// 消息体与 handler.Form 相同，处理流程与 /produce 接口一致，
+// 产品写入成功后才回复QoS1确认
+type MQTTListener struct {
+	options    MQTTOptions
+	tokenLevel int // 设备token在主题中的层级
+	client     mqtt.Client
+	service    *service.ProductService
+	stop       chan struct{} // 停止时中断临时错误的重试
+}
+
+// maxRetryBackoff 服务端临时错误时重试的最大间隔
+const maxRetryBackoff = 30 * time.Second
+
+func NewMQTTListener(s *service.ProductService, options MQTTOptions) (*MQTTListener, error) {
+	var tokenLevel = -1
+	for i, level := range strings.Split(options.Topic, "/") {
+		if level == "+" {
+			tokenLevel = i
+			break
+		}
+	}
+	if tokenLevel < 0 {
+		return nil, fmt.Errorf("mqtt topic %s has no + level for device token", options.Topic)
+	}
+
+	return &MQTTListener{options: options, tokenLevel: tokenLevel, service: s, stop: make(chan struct{})}, nil
+}
+
+// Start 连接服务并订阅主题，断线后自动重连并重新订阅
+func (l *MQTTListener) Start() error {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(l.options.Broker)
+	opts.SetClientID(l.options.ClientID)
+	opts.SetUsername(l.options.Username)
+	opts.SetPassword(l.options.Password)
+	opts.SetCleanSession(false)
+	opts.SetAutoReconnect(true)
+	opts.SetAutoAckDisabled(true)
+	// 消息并发处理，缓冲写入时多条消息合并为同一批次提交
+	opts.SetOrderMatters(false)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		token := client.Subscribe(l.options.Topic, 1, l.handle)
+		if token.Wait() && token.Error() != nil {
+			log.Error("subscribe mqtt topic %s failed: %v", l.options.Topic, token.Error())
+			return
+		}
+		log.Info("subscribed mqtt topic %s on %s", l.options.Topic, l.options.Broker)
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Error("mqtt connection lost: %v", err)
+	})
+
+	l.client = mqtt.NewClient(opts)
+	token := l.client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return errors.New("connect mqtt broker timeout")
+	}
+	return token.Error()
+}
+
+// Stop 中断正在重试的消息并断开连接，未确认的消息在下次连接时重发
+func (l *MQTTListener) Stop() {
+	close(l.stop)
+	if l.client != nil {
+		l.client.Disconnect(250)
+	}
+}
+
+func (l *MQTTListener) handle(client mqtt.Client, msg mqtt.Message) {
+	levels := strings.Split(msg.Topic(), "/")
+	if len(levels) <= l.tokenLevel {
+		log.Error("mqtt topic %s has no device token", msg.Topic())
+		msg.Ack()
+		return
+	}
+
+	var form handler.Form
+	if err := json.Unmarshal(msg.Payload(), &form); err != nil {
+		// 消息体无法解析，重发也无法成功，直接确认
+		log.Error("unmarshal mqtt payload on %s failed: %v", msg.Topic(), err)
+		msg.Ack()
+		return
+	}
+	form.DeviceToken = levels[l.tokenLevel]
+	input, err := form.Input("")
+	if err != nil {
+		log.Error("invalid mqtt payload on %s: %v", msg.Topic(), err)
+		msg.Ack()
+		return
+	}
+
+	// 数据库连接不可用等临时错误时按1s、2s、4s...（最长30s）间隔重试，连接正常时broker不会重发未确认的消息
+	backoff := time.Second
+	for {
+		result, err := l.service.Produce(input)
+		if err == nil {
+			if len(result.PointErrors) > 0 {
+				log.Warn("mqtt message on %s has invalid point values: %v", msg.Topic(), result.PointErrors)
+			}
+			break
+		}
+		var serviceErr *service.Error
+		if !errors.As(err, &serviceErr) || !serviceErr.Temporary() {
+			// 重试也无法成功，确认后丢弃，避免阻塞后续消息
+			log.Error("produce with mqtt message on %s failed, drop it: %v", msg.Topic(), err)
+			break
+		}
+
+		log.Error("produce with mqtt message on %s failed, retry in %v: %v", msg.Topic(), backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-l.stop:
+			// 停止时不确认，下次连接时重发
+			return
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+	msg.Ack()
+}
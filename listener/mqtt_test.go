@@ -0,0 +1,179 @@
+package listener
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"github.com/SasukeBo/pmes-data-producer/service"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// testMessage 记录是否已确认的MQTT消息
+type testMessage struct {
+	topic   string
+	payload []byte
+	acked   int32
+}
+
+func (m *testMessage) Duplicate() bool   { return false }
+func (m *testMessage) Qos() byte         { return 1 }
+func (m *testMessage) Retained() bool    { return false }
+func (m *testMessage) Topic() string     { return m.topic }
+func (m *testMessage) MessageID() uint16 { return 1 }
+func (m *testMessage) Payload() []byte   { return m.payload }
+func (m *testMessage) Ack()              { atomic.StoreInt32(&m.acked, 1) }
+func (m *testMessage) isAcked() bool     { return atomic.LoadInt32(&m.acked) == 1 }
+
+func newTestListener(t *testing.T) *MQTTListener {
+	t.Helper()
+	s := service.NewProductService(service.ProductServiceOptions{MaxMeasuredDelay: time.Hour, MaxMeasuredAhead: time.Hour})
+	l, err := NewMQTTListener(s, MQTTOptions{Topic: "pmes/+/produce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestMQTTHandleAck(t *testing.T) {
+	openTestDB(t, time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location()))
+	device := createTestDevice(t, "device-1")
+	l := newTestListener(t)
+
+	cases := []struct {
+		name     string
+		topic    string
+		payload  string
+		products int
+	}{
+		{"produce", "pmes/device-1/produce", `{"bar_code": "code-1", "qualified": 1, "message_id": "m1"}`, 1},
+		{"resend", "pmes/device-1/produce", `{"bar_code": "code-1", "qualified": 1, "message_id": "m1"}`, 1},
+		{"invalid payload", "pmes/device-1/produce", `{"bar_code":`, 0},
+		{"invalid measured_at", "pmes/device-1/produce", `{"message_id": "m2", "measured_at": "yesterday"}`, 0},
+		{"unknown device", "pmes/device-2/produce", `{"message_id": "m3"}`, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			msg := &testMessage{topic: c.topic, payload: []byte(c.payload)}
+			l.handle(nil, msg)
+			if !msg.isAcked() {
+				t.Error("message should be acked")
+			}
+		})
+	}
+	if count := countProducts(t, device.ID, "m1"); count != 1 {
+		t.Errorf("products of m1 = %v, want 1", count)
+	}
+}
+
+func TestMQTTHandlePermanentError(t *testing.T) {
+	openTestDB(t, time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location()))
+	device := createTestDevice(t, "device-1")
+	l := newTestListener(t)
+
+	// 料号没有当前版本，重试也无法成功，应确认后丢弃
+	if err := orm.DB.Model(&orm.MaterialVersion{}).Where("material_id = ?", device.MaterialID).UpdateColumn("active", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	msg := &testMessage{topic: "pmes/device-1/produce", payload: []byte(`{"bar_code": "code-1", "message_id": "m1"}`)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.handle(nil, msg)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		l.Stop()
+		t.Fatal("permanent error should not be retried")
+	}
+	if !msg.isAcked() || countProducts(t, device.ID, "m1") != 0 {
+		t.Errorf("acked, products = (%v, %v), want (true, 0)", msg.isAcked(), countProducts(t, device.ID, "m1"))
+	}
+}
+
+func TestMQTTHandleRetry(t *testing.T) {
+	openTestDB(t, time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location()))
+	createTestDevice(t, "device-1")
+	l := newTestListener(t)
+
+	// 数据库连接不可用时为临时错误，重试期间不确认，停止时中断重试且不确认，下次连接时由broker重发
+	if err := orm.DB.DB().Close(); err != nil {
+		t.Fatal(err)
+	}
+	msg := &testMessage{topic: "pmes/device-1/produce", payload: []byte(`{"bar_code": "code-1", "message_id": "m1"}`)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.handle(nil, msg)
+	}()
+
+	time.Sleep(1500 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("temporary error should be retried")
+	default:
+	}
+	l.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry is not stopped")
+	}
+	if msg.isAcked() {
+		t.Error("message should not be acked while the database is unavailable")
+	}
+}
+
+// TestMQTTListener 通过MQTT服务收发消息，需设置 MQTT_TEST_BROKER，例如：tcp://127.0.0.1:1883
+func TestMQTTListener(t *testing.T) {
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_TEST_BROKER is not set")
+	}
+	openTestDB(t, time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location()))
+	device := createTestDevice(t, "device-1")
+
+	prefix := fmt.Sprintf("pmes-test-%v", time.Now().UnixNano())
+	s := service.NewProductService(service.ProductServiceOptions{MaxMeasuredDelay: time.Hour, MaxMeasuredAhead: time.Hour})
+	l, err := NewMQTTListener(s, MQTTOptions{Broker: broker, ClientID: prefix + "-listener", Topic: prefix + "/+/produce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+
+	opts := mqtt.NewClientOptions().AddBroker(broker).SetClientID(prefix + "-device")
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		t.Fatalf("connect mqtt broker failed: %v", token.Error())
+	}
+	defer client.Disconnect(250)
+
+	// 订阅在连接回调中完成，重发同一消息直到写入，重传不会重复写入产品
+	topic := prefix + "/device-1/produce"
+	payload := `{"bar_code": "code-1", "qualified": 1, "message_id": "m1", "point_values": "p1:1.5"}`
+	deadline := time.Now().Add(10 * time.Second)
+	for countProducts(t, device.ID, "m1") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("product is not written through mqtt")
+		}
+		if token := client.Publish(topic, 1, false, payload); token.WaitTimeout(5*time.Second) && token.Error() != nil {
+			t.Fatal(token.Error())
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if token := client.Publish(topic, 1, false, payload); token.WaitTimeout(5*time.Second) && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	time.Sleep(500 * time.Millisecond)
+	if count := countProducts(t, device.ID, "m1"); count != 1 {
+		t.Errorf("products of m1 = %v, want 1", count)
+	}
+}
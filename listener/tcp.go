@@ -0,0 +1,165 @@
+package listener
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/service"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TCPOptions TCP行协议接入配置
+type TCPOptions struct {
+	Address   string // 监听地址，例如：:9000
+	Delimiter string // 字段分隔符，默认为 |，不能包含点位检测值及检测时间使用的 ; 和 :
+}
+
+// maxLineSize 单行数据的最大长度，超出时回复错误并关闭连接
+const maxLineSize = 64 * 1024
+
+// TCPListener 接收旧式检测设备通过TCP连接逐行写入的生产数据
+// 每行格式为 TOKEN|BARCODE|QUALIFIED|POINT_VALUES[|MESSAGE_ID[|MEASURED_AT]]，例如：
+//
+//	TOKEN|BARCODE|1|P1:0.12;P2:3.4
+//
+// 每处理一行回写一行结果，成功为 OK，失败为 ERR <reason>
+type TCPListener struct {
+	options  TCPOptions
+	service  *service.ProductService
+	listener net.Listener
+	conns    sync.Map
+	wg       sync.WaitGroup
+}
+
+func NewTCPListener(s *service.ProductService, options TCPOptions) (*TCPListener, error) {
+	if options.Delimiter == "" {
+		options.Delimiter = "|"
+	}
+	if strings.ContainsAny(options.Delimiter, ";:\r\n") {
+		return nil, fmt.Errorf("tcp delimiter %q conflicts with point values, measured_at or line break", options.Delimiter)
+	}
+	return &TCPListener{options: options, service: s}, nil
+}
+
+// Start 开始监听，连接在独立的goroutine中处理
+func (l *TCPListener) Start() error {
+	listener, err := net.Listen("tcp", l.options.Address)
+	if err != nil {
+		return err
+	}
+	l.listener = listener
+	log.Info("tcp listener start on %s", listener.Addr())
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				log.Error("accept tcp connection failed: %v", err)
+				continue
+			}
+			l.wg.Add(1)
+			go func() {
+				defer l.wg.Done()
+				l.serve(conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+// Stop 停止监听，关闭所有连接并等待正在处理的数据完成
+func (l *TCPListener) Stop() {
+	if l.listener == nil {
+		return
+	}
+	_ = l.listener.Close()
+	l.conns.Range(func(key, value interface{}) bool {
+		_ = key.(net.Conn).Close()
+		return true
+	})
+	l.wg.Wait()
+}
+
+func (l *TCPListener) serve(conn net.Conn) {
+	l.conns.Store(conn, struct{}{})
+	defer func() {
+		l.conns.Delete(conn)
+		_ = conn.Close()
+	}()
+
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	writer := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var reply = "OK"
+		input, err := ParseLine(line, l.options.Delimiter)
+		if err == nil {
+			input.IP = ip
+			var result *service.ProduceResult
+			if result, err = l.service.Produce(input); err == nil && len(result.PointErrors) > 0 {
+				log.Warn("tcp line from %s has invalid point values: %v", ip, result.PointErrors)
+			}
+		}
+		if err != nil {
+			log.Error("produce with tcp line from %s failed: %v", ip, err)
+			reply = "ERR " + strings.ReplaceAll(err.Error(), "\n", " ")
+		}
+
+		if _, err := writer.WriteString(reply + "\n"); err != nil {
+			return
+		}
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		// 无法确定下一行的起始位置，回复错误后关闭连接
+		log.Error("tcp line from %s exceeds %v bytes", ip, maxLineSize)
+		_, _ = writer.WriteString(fmt.Sprintf("ERR line exceeds %v bytes\n", maxLineSize))
+		_ = writer.Flush()
+	}
+}
+
+// ParseLine 将一行数据解析为产品接入服务的输入
+func ParseLine(line, delimiter string) (*service.ProduceInput, error) {
+	sectors := strings.Split(line, delimiter)
+	if len(sectors) < 4 {
+		return nil, fmt.Errorf("expect at least 4 fields, got %v", len(sectors))
+	}
+
+	qualified, err := strconv.Atoi(strings.TrimSpace(sectors[2]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid qualified value %s", sectors[2])
+	}
+
+	var input = service.ProduceInput{
+		DeviceToken: strings.TrimSpace(sectors[0]),
+		BarCode:     strings.TrimSpace(sectors[1]),
+		Qualified:   qualified == 1,
+		PointValues: service.ParsePointValuesString(sectors[3]),
+	}
+	if len(sectors) > 4 {
+		input.MessageID = strings.TrimSpace(sectors[4])
+	}
+	if len(sectors) > 5 {
+		if input.MeasuredAt, err = service.ParseMeasuredAt(sectors[5]); err != nil {
+			return nil, err
+		}
+	}
+	return &input, nil
+}
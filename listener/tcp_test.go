@@ -0,0 +1,140 @@
+package listener
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/service"
+)
+
+func TestNewTCPListenerDelimiter(t *testing.T) {
+	for _, delimiter := range []string{";", ":", "|;", "\n"} {
+		if _, err := NewTCPListener(nil, TCPOptions{Delimiter: delimiter}); err == nil {
+			t.Errorf("delimiter %q should be rejected", delimiter)
+		}
+	}
+	for _, delimiter := range []string{"", "|", ",", "\t"} {
+		if _, err := NewTCPListener(nil, TCPOptions{Delimiter: delimiter}); err != nil {
+			t.Errorf("delimiter %q: %v", delimiter, err)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	input, err := ParseLine("token | code-1 | 1 | p1:1.5;p2:2 | m1 | 2026-10-17 08:30:00", "|")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.DeviceToken != "token" || input.BarCode != "code-1" || !input.Qualified || input.MessageID != "m1" {
+		t.Errorf("input = %+v", input)
+	}
+	if len(input.PointValues) != 2 || input.PointValues[1].Name != "p2" || input.PointValues[1].Value != "2" {
+		t.Errorf("point values = %v", input.PointValues)
+	}
+	if input.MeasuredAt.Hour() != 8 || input.MeasuredAt.Minute() != 30 {
+		t.Errorf("measured_at = %v", input.MeasuredAt)
+	}
+
+	for _, line := range []string{
+		"token|code-1|1",
+		"token|code-1|yes|p1:1",
+		"token|code-1|1|p1:1|m1|yesterday",
+	} {
+		if _, err := ParseLine(line, "|"); err == nil {
+			t.Errorf("line %q should be invalid", line)
+		}
+	}
+}
+
+// startTestTCPListener 在本地随机端口启动监听并建立连接
+func startTestTCPListener(t *testing.T) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	s := service.NewProductService(service.ProductServiceOptions{MaxMeasuredDelay: time.Hour, MaxMeasuredAhead: time.Hour})
+	l, err := NewTCPListener(s, TCPOptions{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(l.Stop)
+
+	conn, err := net.Dial("tcp", l.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func readReply(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(reply)
+}
+
+func TestTCPListener(t *testing.T) {
+	openTestDB(t, time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location()))
+	device := createTestDevice(t, "device-1")
+	conn, reader := startTestTCPListener(t)
+
+	// 一次写入多行，空行忽略
+	if _, err := conn.Write([]byte("device-1|code-1|1|p1:1|m1\r\n\ndevice-1|code-2|0|p1:2|m2\n")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if reply := readReply(t, reader); reply != "OK" {
+			t.Errorf("reply %v = %q, want OK", i, reply)
+		}
+	}
+
+	// 一行分多次写入
+	for _, part := range []string{"device-1|co", "de-3|1|p1:", "3|m3\n"} {
+		if _, err := conn.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if reply := readReply(t, reader); reply != "OK" {
+		t.Errorf("reply of partial writes = %q, want OK", reply)
+	}
+
+	// 失败时回复错误，连接继续可用；重传不重复写入
+	if _, err := conn.Write([]byte("device-2|code-4|1|p1:1|m4\ndevice-1|code-5|x|p1:1\ndevice-1|code-1|1|p1:1|m1\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"ERR ", "ERR invalid qualified value x", "OK"} {
+		if reply := readReply(t, reader); !strings.HasPrefix(reply, want) {
+			t.Errorf("reply = %q, want prefix %q", reply, want)
+		}
+	}
+	for _, messageID := range []string{"m1", "m2", "m3"} {
+		if count := countProducts(t, device.ID, messageID); count != 1 {
+			t.Errorf("products of %s = %v, want 1", messageID, count)
+		}
+	}
+}
+
+func TestTCPListenerOversizedLine(t *testing.T) {
+	openTestDB(t, time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location()))
+	createTestDevice(t, "device-1")
+	conn, reader := startTestTCPListener(t)
+
+	go func() {
+		_, _ = conn.Write([]byte("device-1|code-1|1|" + strings.Repeat("p:1;", maxLineSize/4+1) + "\n"))
+	}()
+	if reply := readReply(t, reader); !strings.HasPrefix(reply, "ERR line exceeds") {
+		t.Errorf("reply = %q, want line exceeds error", reply)
+	}
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("connection should be closed after an oversized line")
+	}
+}
@@ -0,0 +1,42 @@
+package orm
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+)
+
+// BackfillQualifiedCounts 由产品表重新计算导入记录及料号版本的完成数、合格数，并据此更新良率
+// 导入记录的完成数及合格数为关联的产品数量及其中合格产品的数量，由同一条语句计算，
+// 料号版本的总数及合格数为已完成导入记录的完成数及合格数之和
+func BackfillQualifiedCounts() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE import_records SET
+			row_finished_count = (SELECT COUNT(*) FROM products WHERE products.import_record_id = import_records.id),
+			qualified_count = (
+				SELECT COUNT(*) FROM products WHERE products.import_record_id = import_records.id AND products.qualified = ?
+			)`, true).Error
+		if err != nil {
+			return fmt.Errorf("backfill counts of import_records failed: %v", err)
+		}
+		err = tx.Model(&ImportRecord{}).Where("row_finished_count > 0").UpdateColumn(
+			"yield", gorm.Expr("1.0 * qualified_count / row_finished_count"),
+		).Error
+		if err != nil {
+			return fmt.Errorf("backfill yield of import_records failed: %v", err)
+		}
+
+		err = tx.Exec(`UPDATE material_versions SET
+			amount = COALESCE((
+				SELECT SUM(import_records.row_finished_count) FROM import_records
+				WHERE import_records.material_version_id = material_versions.id AND import_records.status = ?
+			), 0),
+			qualified_count = COALESCE((
+				SELECT SUM(import_records.qualified_count) FROM import_records
+				WHERE import_records.material_version_id = material_versions.id AND import_records.status = ?
+			), 0)`, ImportStatusFinished, ImportStatusFinished).Error
+		if err != nil {
+			return fmt.Errorf("backfill counts of material_versions failed: %v", err)
+		}
+		return updateVersionYield(tx, "1 = 1")
+	})
+}
@@ -0,0 +1,61 @@
+package orm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBackfillQualifiedCounts(t *testing.T) {
+	openTestDB(t, 0)
+
+	version := MaterialVersion{Version: "v1", MaterialID: 1, Active: true, Amount: 100, QualifiedCount: 1}
+	if err := DB.Create(&version).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 计数与产品表不一致的导入记录，未完成的记录不累加到料号版本
+	var records = []ImportRecord{
+		{FileName: "a", Path: "a", MaterialID: 1, DeviceID: 1, MaterialVersionID: version.ID, Status: ImportStatusFinished, RowFinishedCount: 2, QualifiedCount: 5},
+		{FileName: "b", Path: "b", MaterialID: 1, DeviceID: 1, MaterialVersionID: version.ID, Status: ImportStatusFinished, RowFinishedCount: 9},
+		{FileName: "c", Path: "c", MaterialID: 1, DeviceID: 1, MaterialVersionID: version.ID, Status: ImportStatusImporting},
+	}
+	for i := range records {
+		if err := DB.Create(&records[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	var products = []struct {
+		record    int
+		qualified bool
+	}{{0, true}, {0, true}, {0, false}, {0, true}, {1, false}, {2, true}}
+	for _, p := range products {
+		product := Product{ImportRecordID: records[p.record].ID, MaterialVersionID: version.ID, MaterialID: 1, DeviceID: 1, Qualified: p.qualified, PointValues: Map{}}
+		if err := DB.Create(&product).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := BackfillQualifiedCounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	var want = []struct {
+		finished, qualified int
+		yield               float64
+	}{{4, 3, 0.75}, {1, 0, 0}, {1, 1, 1}}
+	for i, w := range want {
+		var record ImportRecord
+		if err := DB.First(&record, records[i].ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if record.RowFinishedCount != w.finished || record.QualifiedCount != w.qualified || math.Abs(record.Yield-w.yield) > 1e-9 {
+			t.Errorf("record %s = %v/%v yield %v, want %v/%v yield %v",
+				record.FileName, record.RowFinishedCount, record.QualifiedCount, record.Yield, w.finished, w.qualified, w.yield)
+		}
+	}
+	if err := DB.First(&version, version.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if version.Amount != 5 || version.QualifiedCount != 3 || math.Abs(version.Yield-0.6) > 1e-9 {
+		t.Errorf("version = %v/%v yield %v, want 5/3 yield 0.6", version.Amount, version.QualifiedCount, version.Yield)
+	}
+}
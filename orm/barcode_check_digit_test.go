@@ -0,0 +1,83 @@
+package orm
+
+import (
+	"testing"
+)
+
+func TestComputeCheckDigit(t *testing.T) {
+	var cases = []struct {
+		algorithm string
+		data      string
+		want      string
+		ok        bool
+	}{
+		{CheckDigitLuhn, "7992739871", "3", true},
+		{CheckDigitLuhn, "453957876362148", "6", true},
+		{CheckDigitLuhn, "0", "0", true},
+		{CheckDigitLuhn, "79927A9871", "", false},
+		{CheckDigitMod11, "123456789", "2", true},
+		{CheckDigitMod11, "104", "X", true},
+		{CheckDigitMod11, "109", "0", true},
+		{CheckDigitMod11, "12-4", "", false},
+		{CheckDigitMod43, "CODE39", "W", true},
+		{CheckDigitMod43, "code39", "W", true},
+		{CheckDigitMod43, "WIKIPEDIA", "$", true},
+		{CheckDigitMod43, "A B", "G", true},
+		{CheckDigitMod43, "ABC#", "", false},
+		{CheckDigitMod37_36, "A12425GABC1234002", "M", true},
+		{CheckDigitMod37_36, "a12425gabc1234002", "M", true},
+		{CheckDigitMod37_36, "A1-2", "", false},
+		{CheckDigitGS1, "400638133393", "1", true},
+		{CheckDigitGS1, "03600029145", "2", true},
+		{CheckDigitGS1, "00614141123456789", "0", true},
+		{CheckDigitGS1, "4006381333A3", "", false},
+		{"crc", "1234", "", false},
+		{CheckDigitLuhn, "", "", false},
+	}
+	for _, c := range cases {
+		got, err := ComputeCheckDigit(c.algorithm, c.data)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("%s(%q) = (%q, %v), want %q ok %v", c.algorithm, c.data, got, err, c.want, c.ok)
+		}
+	}
+}
+
+func TestVerifyCheckDigit(t *testing.T) {
+	var cases = []struct {
+		check BarCodeCheckDigit
+		code  string
+		ok    bool
+	}{
+		{BarCodeCheckDigit{Algorithm: CheckDigitGS1, IndexRange: []int{1, 12}}, "4006381333931", true},
+		{BarCodeCheckDigit{Algorithm: CheckDigitGS1, IndexRange: []int{1, 12}}, "4006381333932", false},
+		{BarCodeCheckDigit{Algorithm: CheckDigitGS1, IndexRange: []int{1, 12}}, "4006381333391", false},
+		{BarCodeCheckDigit{Algorithm: CheckDigitLuhn, IndexRange: []int{3, 12}}, "SN79927398713", true},
+		{BarCodeCheckDigit{Algorithm: CheckDigitLuhn, IndexRange: []int{3, 12}}, "SN79927398710", false},
+		// 校验位不在数据位之后
+		{BarCodeCheckDigit{Algorithm: CheckDigitMod11, IndexRange: []int{2, 4}, CheckIndex: 1}, "X104", true},
+		{BarCodeCheckDigit{Algorithm: CheckDigitMod11, IndexRange: []int{2, 4}, CheckIndex: 1}, "x104", true},
+		{BarCodeCheckDigit{Algorithm: CheckDigitMod11, IndexRange: []int{2, 4}, CheckIndex: 1}, "0104", false},
+		{BarCodeCheckDigit{Algorithm: CheckDigitMod43, IndexRange: []int{1, 6}}, "CODE39W", true},
+		{BarCodeCheckDigit{Algorithm: CheckDigitMod43, IndexRange: []int{1, 6}}, "CODE39X", false},
+		{BarCodeCheckDigit{Algorithm: CheckDigitMod37_36, IndexRange: []int{1, 17}}, "A12425GABC1234002M", true},
+		{BarCodeCheckDigit{Algorithm: CheckDigitMod37_36, IndexRange: []int{1, 17}}, "A12425GABC1234003M", false},
+		// 索引区间或校验位超出条码长度
+		{BarCodeCheckDigit{Algorithm: CheckDigitGS1, IndexRange: []int{1, 12}}, "400638133393", false},
+		{BarCodeCheckDigit{Algorithm: CheckDigitGS1, IndexRange: []int{1, 20}}, "4006381333931", false},
+	}
+	for _, c := range cases {
+		if err := c.check.Verify(c.code); (err == nil) != c.ok {
+			t.Errorf("verify %s %q: err = %v, want ok %v", c.check.Algorithm, c.code, err, c.ok)
+		}
+	}
+
+	decoder := newTestDecoder(t, 13, `{
+		"check_digit": {"algorithm": "GS1", "index_range": [1, 12]},
+		"items": [{"key": "C", "type": "Category", "index_range": [1, 3]}]
+	}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"4006381333931", BarCodeStatusSuccess, Map{"C": "400"}},
+		{"4006381333932", BarCodeStatusCheckFail, nil},
+		{"400638133393", BarCodeStatusTooShort, nil},
+	})
+}
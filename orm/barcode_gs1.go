@@ -0,0 +1,266 @@
+package orm
+
+import (
+	"fmt"
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gs1Separator GS1 可变长度字段的分隔符（FNC1 / GS）
+const gs1Separator = "\x1d"
+
+// gs1SymbologyIDs 扫码枪可能输出的码制标识前缀
+var gs1SymbologyIDs = []string{"]d2", "]C1", "]e0", "]Q3", "]J1"}
+
+// gs1AI 应用标识符的格式
+type gs1AI struct {
+	length   int  // 固定长度，为0时为可变长度
+	max      int  // 可变长度的最大长度
+	numeric  bool // 是否只能为数字
+	date     bool // 是否为 YYMMDD 日期
+	dateTime bool // 是否为 YYMMDDHHMM 日期时间
+	check    bool // 末位是否为 GS1 mod 10 校验位
+	measure  bool // 是否为计量值，解析为浮点数
+	decimals int  // 计量值的小数位数
+}
+
+// gs1AIs 支持的应用标识符，其余AI（例如 253、8003 等组合格式的AI）视为不支持，解析时返回非法条码
+// 计量值AI 310n-369n 由 init 生成
+var gs1AIs = map[string]gs1AI{
+	"00":  {length: 18, numeric: true, check: true}, // SSCC
+	"01":  {length: 14, numeric: true, check: true}, // GTIN
+	"02":  {length: 14, numeric: true, check: true}, // 所含贸易项目的 GTIN
+	"10":  {max: 20},                                // 批号
+	"11":  {length: 6, numeric: true, date: true},   // 生产日期
+	"12":  {length: 6, numeric: true, date: true},   // 付款截止日期
+	"13":  {length: 6, numeric: true, date: true},   // 包装日期
+	"15":  {length: 6, numeric: true, date: true},   // 保质期
+	"16":  {length: 6, numeric: true, date: true},   // 销售截止日期
+	"17":  {length: 6, numeric: true, date: true},   // 有效期
+	"20":  {length: 2, numeric: true},               // 产品变体
+	"21":  {max: 20},                                // 序列号
+	"22":  {max: 20},                                // 消费品变体
+	"30":  {max: 8, numeric: true},                  // 数量
+	"37":  {max: 8, numeric: true},                  // 所含贸易项目数量
+	"240": {max: 30},                                // 附加产品标识
+	"241": {max: 30},                                // 客户零件号
+	"250": {max: 30},                                // 二级序列号
+	"400": {max: 30},                                // 订单号
+	"90":  {max: 30},                                // 双方约定信息
+	"91":  {max: 90},                                // 公司内部信息
+	"92":  {max: 90},
+	"93":  {max: 90},
+	"94":  {max: 90},
+	"95":  {max: 90},
+	"96":  {max: 90},
+	"97":  {max: 90},
+	"98":  {max: 90},
+	"99":  {max: 90},
+
+	"7001": {length: 13, numeric: true},                 // 北约物资代码
+	"7003": {length: 10, numeric: true, dateTime: true}, // 有效期的日期及时间
+	"8004": {max: 30},                                   // 全球单个资产代码 GIAI
+	"8020": {max: 25},                                   // 付款单号
+}
+
+// gs1MeasureAIs 计量值AI的前三位，第四位 n 为小数位数（0-5），值为6位数字，例如 3103 为净重（千克）3位小数
+var gs1MeasureAIs = [][2]int{{310, 316}, {320, 329}, {330, 337}, {340, 349}, {350, 357}, {360, 369}}
+
+func init() {
+	for _, prefixes := range gs1MeasureAIs {
+		for prefix := prefixes[0]; prefix <= prefixes[1]; prefix++ {
+			for n := 0; n <= 5; n++ {
+				gs1AIs[fmt.Sprintf("%d%d", prefix, n)] = gs1AI{length: 6, numeric: true, measure: true, decimals: n}
+			}
+		}
+	}
+}
+
+// BarCodeGS1 编码规则的 GS1 解析配置，存储在 BarCodeRule.Items 的 gs1 中
+//   - Names 应用标识符对应的解析结果名称，例如 {"01": "GTIN", "10": "Batch"}，未配置的AI以AI本身为名称
+//   - Required 必须包含的应用标识符，缺少时视为非法条码
+//   - Separator 可变长度字段的分隔符，为空时为 GS（\x1d）
+type BarCodeGS1 struct {
+	Names     map[string]string `json:"names"`
+	Required  []string          `json:"required"`
+	Separator string            `json:"separator"`
+}
+
+// gs1DecodeError GS1 解析错误及对应的条码状态
+type gs1DecodeError struct {
+	status int
+	err    error
+}
+
+func (e *gs1DecodeError) Error() string {
+	return e.err.Error()
+}
+
+func newGS1Error(status int, format string, args ...interface{}) *gs1DecodeError {
+	return &gs1DecodeError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// Decode 按应用标识符解析 GS1 条码
+// 不支持的AI（见 gs1AIs）、非法的日期或数字视为 BarCodeStatusIllegal，长度不足或超长视为 BarCodeStatusTooShort，
+// GTIN、SSCC 校验位错误视为 BarCodeStatusCheckFail
+func (g *BarCodeGS1) Decode(code string) (Map, *gs1DecodeError) {
+	separator := g.Separator
+	if separator == "" {
+		separator = gs1Separator
+	}
+	for _, id := range gs1SymbologyIDs {
+		if strings.HasPrefix(code, id) {
+			code = code[len(id):]
+			break
+		}
+	}
+
+	var out = make(Map)
+	var found = make(map[string]bool)
+	for {
+		code = strings.TrimPrefix(code, separator)
+		if code == "" {
+			break
+		}
+
+		ai, format, ok := matchGS1AI(code)
+		if !ok {
+			return out, newGS1Error(BarCodeStatusIllegal, "unsupported gs1 application identifier at %s", code)
+		}
+		code = code[len(ai):]
+
+		var value string
+		if format.length > 0 {
+			if len(code) < format.length {
+				return out, newGS1Error(BarCodeStatusTooShort, "gs1 ai (%s) requires %v characters, got %s", ai, format.length, code)
+			}
+			value, code = code[:format.length], code[format.length:]
+		} else {
+			end := strings.Index(code, separator)
+			if end < 0 {
+				end = len(code)
+			}
+			value, code = code[:end], code[end:]
+			if value == "" || len(value) > format.max {
+				return out, newGS1Error(BarCodeStatusTooShort, "gs1 ai (%s) length should be 1 - %v, got %s", ai, format.max, value)
+			}
+		}
+
+		parsed, err := format.parse(value)
+		if err != nil {
+			return out, err
+		}
+		found[ai] = true
+		if name, ok := g.Names[ai]; ok && name != "" {
+			out[name] = parsed
+		} else {
+			out[ai] = parsed
+		}
+	}
+
+	for _, ai := range g.Required {
+		if !found[ai] {
+			return out, newGS1Error(BarCodeStatusIllegal, "gs1 ai (%s) is required", ai)
+		}
+	}
+	return out, nil
+}
+
+// matchGS1AI 匹配条码开头的应用标识符，AI长度为2至4位
+func matchGS1AI(code string) (string, gs1AI, bool) {
+	for length := 2; length <= 4 && length <= len(code); length++ {
+		if format, ok := gs1AIs[code[:length]]; ok {
+			return code[:length], format, true
+		}
+	}
+	return "", gs1AI{}, false
+}
+
+// parse 校验并转换AI的值，日期及日期时间转换为时间，计量值转换为浮点数，其余为字符串
+func (f gs1AI) parse(value string) (interface{}, *gs1DecodeError) {
+	if f.numeric {
+		if _, err := parseDigits(value); err != nil {
+			return nil, newGS1Error(BarCodeStatusIllegal, "gs1 value %s is not numeric", value)
+		}
+	}
+	if f.check {
+		expect, _ := ComputeCheckDigit(CheckDigitGS1, value[:len(value)-1])
+		if expect != value[len(value)-1:] {
+			return nil, newGS1Error(BarCodeStatusCheckFail, "check digit of %s mismatch, expect %s", value, expect)
+		}
+	}
+	if f.date || f.dateTime {
+		t, err := parseGS1Date(value[:6])
+		if err != nil {
+			return nil, newGS1Error(BarCodeStatusIllegal, "%v", err)
+		}
+		if f.dateTime {
+			hour, _ := strconv.Atoi(value[6:8])
+			minute, _ := strconv.Atoi(value[8:10])
+			if value[4:6] == "00" || hour > 23 || minute > 59 {
+				return nil, newGS1Error(BarCodeStatusIllegal, "invalid gs1 date time %s", value)
+			}
+			t = t.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+		}
+		return t, nil
+	}
+	if f.measure {
+		point := len(value) - f.decimals
+		measure, _ := strconv.ParseFloat(value[:point]+"."+value[point:], 64)
+		return measure, nil
+	}
+	return value, nil
+}
+
+// parseGS1Date 解析 YYMMDD 日期，日为00时表示当月最后一天
+// 年份按 GS1 规范取当前年份前49年至后50年之间的年份
+func parseGS1Date(value string) (time.Time, error) {
+	yy, mm, dd := int(value[0]-'0')*10+int(value[1]-'0'), int(value[2]-'0')*10+int(value[3]-'0'), int(value[4]-'0')*10+int(value[5]-'0')
+	if mm < 1 || mm > 12 {
+		return time.Time{}, fmt.Errorf("invalid gs1 date %s", value)
+	}
+
+	now := clock.Now()
+	year := now.Year() - now.Year()%100 + yy
+	switch diff := yy - now.Year()%100; {
+	case diff >= 51:
+		year -= 100
+	case diff <= -50:
+		year += 100
+	}
+
+	if dd == 0 {
+		return time.Date(year, time.Month(mm)+1, 0, 0, 0, 0, 0, clock.Location()), nil
+	}
+	t := time.Date(year, time.Month(mm), dd, 0, 0, 0, 0, clock.Location())
+	if t.Day() != dd {
+		return time.Time{}, fmt.Errorf("invalid gs1 date %s", value)
+	}
+	return t, nil
+}
+
+// decodeBarCodeGS1 解析编码规则中的 GS1 配置
+func decodeBarCodeGS1(value interface{}) *BarCodeGS1 {
+	var config = BarCodeGS1{Names: make(map[string]string)}
+	item, ok := value.(map[string]interface{})
+	if !ok {
+		return &config
+	}
+
+	if names, ok := item["names"].(map[string]interface{}); ok {
+		for ai, name := range names {
+			config.Names[ai] = fmt.Sprint(name)
+		}
+	}
+	if required, ok := item["required"].([]interface{}); ok {
+		for _, ai := range required {
+			config.Required = append(config.Required, fmt.Sprint(ai))
+		}
+	}
+	if separator, ok := item["separator"].(string); ok {
+		config.Separator = separator
+	}
+	return &config
+}
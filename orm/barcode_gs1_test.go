@@ -0,0 +1,53 @@
+package orm
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeGS1(t *testing.T) {
+	setTestClock(t)
+
+	decoder := newTestDecoder(t, 0, `{"mode": "gs1", "gs1": {"names": {"01": "GTIN", "10": "Batch"}}}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		// 码制标识前缀，可变长度字段以 GS 结束
+		{"]d201040063813339311726123110LOT42\x1d21SN1", BarCodeStatusSuccess, Map{
+			"GTIN": "04006381333931", "17": date(2026, 12, 31), "Batch": "LOT42", "21": "SN1",
+		}},
+		{"0104006381333931\x1d10LOT42", BarCodeStatusSuccess, Map{"GTIN": "04006381333931", "Batch": "LOT42"}},
+		{"00006141411234567890", BarCodeStatusSuccess, Map{"00": "006141411234567890"}},
+		// 日为00时为当月最后一天，年份取当前年份前49年至后50年
+		{"17261100", BarCodeStatusSuccess, Map{"17": date(2026, 11, 30)}},
+		{"11770101", BarCodeStatusSuccess, Map{"11": date(1977, 1, 1)}},
+		{"17760101", BarCodeStatusSuccess, Map{"17": date(2076, 1, 1)}},
+		// 4位AI
+		{"3103001250", BarCodeStatusSuccess, Map{"3103": 1.25}},
+		{"3202012345\x1d3300000007", BarCodeStatusSuccess, Map{"3202": 123.45, "3300": 7.0}},
+		{"70032612312359", BarCodeStatusSuccess, Map{"7003": date(2026, 12, 31).Add(23*time.Hour + 59*time.Minute)}},
+		{"70011234567890123", BarCodeStatusSuccess, Map{"7001": "1234567890123"}},
+		{"8004ASSET-001\x1d8020PAY42", BarCodeStatusSuccess, Map{"8004": "ASSET-001", "8020": "PAY42"}},
+		{"3012\x1d375", BarCodeStatusSuccess, Map{"30": "12", "37": "5"}},
+
+		{"0104006381333932", BarCodeStatusCheckFail, nil},
+		{"0104006381", BarCodeStatusTooShort, nil},
+		{"10" + strings.Repeat("A", 21), BarCodeStatusTooShort, nil},
+		{"10\x1d21SN1", BarCodeStatusTooShort, nil},
+		{"17261301", BarCodeStatusIllegal, nil},
+		{"17260230", BarCodeStatusIllegal, nil},
+		{"3012A", BarCodeStatusIllegal, nil},
+		{"70032612312460", BarCodeStatusIllegal, nil},
+		{"70032612002359", BarCodeStatusIllegal, nil},
+		// 不支持的AI
+		{"2531234567890128", BarCodeStatusIllegal, nil},
+		{"3106001250", BarCodeStatusIllegal, nil},
+		{"8003012345678901", BarCodeStatusIllegal, nil},
+		{"ERR", BarCodeStatusReadFail, nil},
+	})
+
+	decoder = newTestDecoder(t, 0, `{"mode": "gs1", "gs1": {"required": ["01"], "separator": "|"}}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"0104006381333931|10LOT42|21SN1", BarCodeStatusSuccess, Map{"01": "04006381333931", "10": "LOT42", "21": "SN1"}},
+		{"10LOT42|21SN1", BarCodeStatusIllegal, nil},
+	})
+}
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/clock"
 	"github.com/jinzhu/gorm"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -16,22 +19,45 @@ type BarCodeRule struct {
 	Name       string `gorm:"COMMENT:'编码规则名称';not null;unique_index"` // 编码规则名称
 	Remark     string `gorm:"COMMENT:'编码规则描述';not null"`              // 规则描述
 	UserID     uint   `gorm:"COMMENT:'编码规则创建人'"`                      // 创建人ID
-	Items      Map    `gorm:"COMMENT:'解析项配置';type:JSON;not null"`     // 存储解析规则
+	Items      Map    `gorm:"COMMENT:'解析项配置';not null"`               // 存储解析规则
 }
 
 const (
 	BarCodeItemTypeCategory = "Category"
 	BarCodeItemTypeDatetime = "Datetime"
 	BarCodeItemTypeWeekday  = "Weekday"
+	BarCodeItemTypeYear     = "Year"     // 年份编码，单字符、两位或四位
+	BarCodeItemTypeJulian   = "Julian"   // 年内天数，DDD，可带年份前缀 YDDD、YYDDD、YYYYDDD
+	BarCodeItemTypeFullDate = "FullDate" // 完整日期，按 Layout 解析，例如 YYMMDD、YYYYMMDD
+	BarCodeItemTypeSerial   = "Serial"   // 流水号，按 Radix 或 Alphabet 解析为整数
+)
+
+// 编码规则的解析方式，存储在 BarCodeRule.Items 的 mode 中
+//   - regex 模式的正则表达式存储在 pattern 中，解析项通过 Group 引用命名分组
+//   - delimiter 模式的分隔符存储在 delimiter 中，field_count 不为0时校验字段数量，解析项通过 Field 引用字段
+const (
+	BarCodeModePositional = "positional" // 按索引区间解析，默认方式
+	BarCodeModeGS1        = "gs1"        // 按 GS1 应用标识符（AI）解析
+	BarCodeModeRegex      = "regex"      // 按正则表达式的命名分组解析
+	BarCodeModeDelimiter  = "delimiter"  // 按分隔符拆分字段解析
 )
 
 // BarCodeItem 二维码识别规则对象
 // - IndexRange 表示识别码索引范围，为整型数组，大于等于两位时，取前两位索引范围的字符，一位时，取该位索引为字符，0位时忽略该规则。
-// - Type 值类型，主要分两类Category和Date，前者一律处理为字符串，后者解析为日期
-// - 当Type=Date时，有以下字段
+// - Type 值类型，Category 一律处理为字符串，Datetime、Weekday、Year、Julian、FullDate 解析为日期（Year 为年份），Serial 解析为整数
+// - 当Type=Datetime时，有以下字段
 // - DayCode - 日期编码，为字符串数组，长度应该大于等于2，前两位表示编码起始字符，按照1-9 A-Z的顺序，从第三个元素开始为剔除字符，即从编码起始
 //   字符中剔除这些字符。当位数小于2时，视为无DayCode，则对应日期按照检测时间的日期补全。[1, Y, B, I, O] 表示从1到Y，去除B，I，O。
 // - MonthCode - 月份编码，字符串数组，规则同DayCode。 [1, D, A] 表示从1到D，去除A。
+// - 当Type=FullDate时，按 Layout（YYYY、YY、MM、DD 的组合）解析完整日期，Layout 为空时按长度使用 YYMMDD 或 YYYYMMDD
+// - 当Type=Year时，YearCode 为单字符年份的编码区间，规则同DayCode，YearBase 为编码区间第一个字符对应的年份；
+//   没有 YearCode 时，单字符为年份末位，两位为年份后两位，四位为完整年份
+// - Year 与 Datetime、Weekday、Julian 解析项使用相同的 Key 时组合为完整日期，否则日期的年份按检测时间补全，
+//   补全后晚于当天的日期视为上一年（或上一月）
+// - regex、delimiter 模式下，Group、Field 指定解析项对应的命名分组或字段（从1开始），此时 IndexRange
+//   为该分组或字段内的索引区间，为空时取整个分组或字段
+// - 当Type=Serial时，条码段按 Alphabet 中字符的序号解析，没有 Alphabet 时按 Radix 进制解析（默认十进制，
+//   不区分大小写），Min、Max 不为空时校验取值范围，超出范围视为非法条码
 type BarCodeItem struct {
 	Label           string   `json:"label"`             // 解析项的名称，例如：冲压日期
 	Key             string   `json:"key"`               // 解析项的英文标识，例如：ProduceDate
@@ -41,7 +67,17 @@ type BarCodeItem struct {
 	DayCodeReject   []string `json:"day_code_reject"`   // 日码区间剔除字段
 	MonthCode       []string `json:"month_code"`        // 月码区间
 	MonthCodeReject []string `json:"month_code_reject"` // 月码区间剔除字段
+	YearCode        []string `json:"year_code"`         // 年码区间
+	YearCodeReject  []string `json:"year_code_reject"`  // 年码区间剔除字段
+	YearBase        int      `json:"year_base"`         // 年码区间起始字符对应的年份，例如：2010
+	Layout          string   `json:"layout"`            // 日期格式，例如：YYMMDD
+	Radix           int      `json:"radix"`             // 流水号进制，例如：10、16、36
+	Alphabet        string   `json:"alphabet"`          // 流水号字符集，按字符顺序表示0、1、2...
+	Min             *int64   `json:"min"`               // 流水号最小值
+	Max             *int64   `json:"max"`               // 流水号最大值
 	CategorySet     []string `json:"category_set"`      // 类别取值区间
+	Group           string   `json:"group"`             // 正则表达式的命名分组，例如：LOT
+	Field           int      `json:"field"`             // 分隔后的字段序号，例如：2
 }
 
 func (r *BarCodeRule) Get(id uint) error {
@@ -53,16 +89,29 @@ func (r *BarCodeRule) Get(id uint) error {
 }
 
 const (
-	BarCodeStatusSuccess  = 1 + iota
-	BarCodeStatusIllegal  // 条码值非法
-	BarCodeStatusReadFail // 条码读取错误
-	BarCodeStatusTooShort // 条码长度错误
-	BarCodeStatusNoRule   // 条码规则无解析项
+	BarCodeStatusSuccess   = 1 + iota
+	BarCodeStatusIllegal   // 条码值非法
+	BarCodeStatusReadFail  // 条码读取错误
+	BarCodeStatusTooShort  // 条码长度错误
+	BarCodeStatusNoRule    // 条码规则无解析项
+	BarCodeStatusCheckFail // 条码校验位错误
 )
 
 type BarCodeDecoder struct {
 	Rules       []BarCodeItem
 	BarCodeRule *BarCodeRule
+	CheckDigit  *BarCodeCheckDigit // 校验位配置，为nil时不校验
+	Mode        string             // 解析方式，默认按索引区间解析
+	GS1         *BarCodeGS1        // Mode 为 gs1 时的解析配置
+	Pattern     *regexp.Regexp     // Mode 为 regex 时的正则表达式
+	Delimiter   string             // Mode 为 delimiter 时的分隔符
+	FieldCount  int                // Mode 为 delimiter 时的字段数量，为0时不校验
+}
+
+// barCodeFields regex、delimiter 模式下拆分出的命名分组及字段
+type barCodeFields struct {
+	groups map[string]string
+	fields []string
 }
 
 // Decode 解析识别码，返回解析结果对象 及 状态码
@@ -71,34 +120,83 @@ type BarCodeDecoder struct {
 // - 2 识别码不符合编码规则
 // - 3 识别码读取失败，为空字符串或ERR
 // - 4 识别码长度不正确
+// - 6 识别码校验位错误
 func (bdc *BarCodeDecoder) Decode(code string) (out Map, statusCode int) {
 	out = make(Map)
 	if code == "" || strings.ToUpper(code) == "ERR" {
 		statusCode = BarCodeStatusReadFail
 		return
 	}
-	if len(code) != bdc.BarCodeRule.CodeLength {
-		statusCode = BarCodeStatusTooShort
+	// GS1 条码为可变长度，按应用标识符解析，不校验编码长度
+	if bdc.Mode == BarCodeModeGS1 {
+		var err *gs1DecodeError
+		if out, err = bdc.GS1.Decode(code); err != nil {
+			log.Errorln(err)
+			statusCode = err.status
+			return
+		}
+		statusCode = BarCodeStatusSuccess
 		return
 	}
 
+	// regex、delimiter 模式的条码为可变长度，按分组或字段截取解析项
+	var fields *barCodeFields
+	switch bdc.Mode {
+	case BarCodeModeRegex:
+		match := bdc.Pattern.FindStringSubmatch(code)
+		if match == nil {
+			statusCode = BarCodeStatusIllegal
+			return
+		}
+		fields = &barCodeFields{groups: make(map[string]string)}
+		for idx, name := range bdc.Pattern.SubexpNames() {
+			if name != "" {
+				fields.groups[name] = match[idx]
+			}
+		}
+	case BarCodeModeDelimiter:
+		fields = &barCodeFields{fields: strings.Split(code, bdc.Delimiter)}
+		if bdc.FieldCount > 0 && len(fields.fields) != bdc.FieldCount {
+			statusCode = BarCodeStatusTooShort
+			return
+		}
+	default:
+		if len(code) != bdc.BarCodeRule.CodeLength {
+			statusCode = BarCodeStatusTooShort
+			return
+		}
+	}
+	if bdc.CheckDigit != nil {
+		if err := bdc.CheckDigit.Verify(code); err != nil {
+			log.Errorln(err)
+			statusCode = BarCodeStatusCheckFail
+			return
+		}
+	}
+
+	// 年份先于日期解析，供相同 Key 的日期解析项组合
+	var years = make(map[string]int)
 	for _, rule := range bdc.Rules {
-		var begin, end int
-		if len(rule.IndexRange) > 0 {
-			begin = rule.IndexRange[0]
+		if rule.Type != BarCodeItemTypeYear {
+			continue
 		}
-		if len(rule.IndexRange) > 1 {
-			end = rule.IndexRange[1]
+		childStr, ok := rule.segmentOf(code, fields)
+		if !ok {
+			continue
 		}
-		var childStr string
-		if end != 0 {
-			childStr = code[begin-1 : end]
-		} else {
-			childStr = string(code[begin-1])
+		year, err := parseYearCode(childStr, rule)
+		if err != nil {
+			log.Errorln(err)
+			statusCode = BarCodeStatusIllegal
+			return
 		}
+		years[rule.Key] = year
+		out[rule.Key] = year
+	}
 
-		// 如果条码段包含*号，表示补位，跳过此解析项
-		if strings.Contains(childStr, "*") {
+	for _, rule := range bdc.Rules {
+		childStr, ok := rule.segmentOf(code, fields)
+		if !ok {
 			continue
 		}
 
@@ -126,9 +224,9 @@ func (bdc *BarCodeDecoder) Decode(code string) (out Map, statusCode int) {
 			var err error
 
 			if len(timeCode) > 1 {
-				t, err = parseCodeDatetime(timeCode[:1], timeCode[1:2], rule)
+				t, err = parseCodeDatetime(years[rule.Key], timeCode[:1], timeCode[1:2], rule)
 			} else if len(timeCode) > 0 {
-				t, err = parseCodeDatetime("", timeCode, rule)
+				t, err = parseCodeDatetime(years[rule.Key], "", timeCode, rule)
 			}
 
 			if err != nil {
@@ -137,7 +235,7 @@ func (bdc *BarCodeDecoder) Decode(code string) (out Map, statusCode int) {
 			}
 
 			if t == nil {
-				out[rule.Key] = time.Now()
+				out[rule.Key] = clock.Now()
 			} else {
 				out[rule.Key] = *t
 			}
@@ -157,8 +255,32 @@ func (bdc *BarCodeDecoder) Decode(code string) (out Map, statusCode int) {
 				return
 			}
 			weekDay, err := strconv.ParseInt(weekCode[2:], 10, 64)
-			t = parseTimeFromWeekday(int(week), int(weekDay-1))
+			t = parseTimeFromWeekday(years[rule.Key], int(week), int(weekDay-1))
+			out[rule.Key] = *t
+		case BarCodeItemTypeJulian:
+			t, err := parseJulianDate(years[rule.Key], childStr, rule)
+			if err != nil {
+				log.Errorln(err)
+				statusCode = BarCodeStatusIllegal
+				return
+			}
+			out[rule.Key] = *t
+		case BarCodeItemTypeFullDate:
+			t, err := parseLayoutDate(childStr, rule.Layout)
+			if err != nil {
+				log.Errorln(err)
+				statusCode = BarCodeStatusIllegal
+				return
+			}
 			out[rule.Key] = *t
+		case BarCodeItemTypeSerial:
+			serial, err := parseSerial(childStr, rule)
+			if err != nil {
+				log.Errorln(err)
+				statusCode = BarCodeStatusIllegal
+				return
+			}
+			out[rule.Key] = serial
 		}
 	}
 
@@ -166,7 +288,58 @@ func (bdc *BarCodeDecoder) Decode(code string) (out Map, statusCode int) {
 	return
 }
 
-func parseCodeDatetime(monthCode, dayCode string, rule BarCodeItem) (*time.Time, error) {
+// segmentOf 截取解析项对应的条码段，fields 不为nil时先取解析项引用的命名分组或字段
+// 分组未匹配或字段不存在时返回false，跳过此解析项
+func (item *BarCodeItem) segmentOf(code string, fields *barCodeFields) (string, bool) {
+	if fields != nil {
+		var referenced bool
+		switch {
+		case fields.groups != nil && item.Group != "":
+			code, referenced = fields.groups[item.Group], true
+		case fields.fields != nil && item.Field > 0:
+			if item.Field > len(fields.fields) {
+				return "", false
+			}
+			code, referenced = fields.fields[item.Field-1], true
+		}
+		if code == "" {
+			return "", false
+		}
+		if referenced && len(item.IndexRange) == 0 {
+			return code, !strings.Contains(code, "*")
+		}
+	}
+	return item.segment(code)
+}
+
+// segment 截取解析项索引区间对应的条码段
+// 索引区间为空、超出条码长度或条码段包含*号（补位）时返回false，跳过此解析项
+func (item *BarCodeItem) segment(code string) (string, bool) {
+	var begin, end int
+	if len(item.IndexRange) > 0 {
+		begin = item.IndexRange[0]
+	}
+	if len(item.IndexRange) > 1 {
+		end = item.IndexRange[1]
+	}
+	if begin < 1 || begin > len(code) || end > len(code) || (end != 0 && end < begin) {
+		return "", false
+	}
+
+	var childStr string
+	if end != 0 {
+		childStr = code[begin-1 : end]
+	} else {
+		childStr = string(code[begin-1])
+	}
+	if strings.Contains(childStr, "*") {
+		return "", false
+	}
+	return childStr, true
+}
+
+// parseCodeDatetime 解析月码及日码，year 为0时按检测时间补全年份
+func parseCodeDatetime(year int, monthCode, dayCode string, rule BarCodeItem) (*time.Time, error) {
 	var month, day int
 	var err error
 
@@ -196,15 +369,28 @@ func parseCodeDatetime(monthCode, dayCode string, rule BarCodeItem) (*time.Time,
 		}
 	}
 
-	now := time.Now()
+	now := clock.Now()
+	var monthInferred = month == 0
 	if month == 0 {
 		month = int(now.Month())
 	}
 	if day == 0 {
 		day = now.Day()
 	}
+	if year != 0 {
+		t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, clock.Location())
+		return &t, nil
+	}
 
-	t := time.Date(now.Year(), time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	// 年份按检测时间补全，晚于当天时为上一年，未编码月份时为上一月
+	t := time.Date(now.Year(), time.Month(month), day, 0, 0, 0, 0, clock.Location())
+	if t.After(clock.Today()) {
+		if monthInferred {
+			t = time.Date(now.Year(), time.Month(month)-1, day, 0, 0, 0, 0, clock.Location())
+		} else {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
 	return &t, nil
 }
 
@@ -221,7 +407,8 @@ func parseIndexInCodeRange(code, begin, end string, rejects ...string) (int, err
 				distance--
 			}
 		}
-		if ascii >= uint8('A') {
+		// 数字与大写字母之间的7个字符不计入编码
+		if ascii >= uint8('A') && begin[0] <= uint8('9') {
 			distance = distance - 7
 		}
 	} else {
@@ -231,17 +418,206 @@ func parseIndexInCodeRange(code, begin, end string, rejects ...string) (int, err
 	return int(distance + 1), nil
 }
 
-func parseTimeFromWeekday(week, day int) *time.Time {
-	now := time.Now()
-	t := time.Date(now.Year(), time.January, 7*(week-1), 0, 0, 0, 0, time.UTC)
+// parseTimeFromWeekday 由周数及周内天数计算日期，year 为0时按检测时间补全，晚于当天时为上一年
+func parseTimeFromWeekday(year, week, day int) *time.Time {
+	var inferred = year == 0
+	if inferred {
+		year = clock.Now().Year()
+	}
+	nt := weekdayDate(year, week, day)
+	if inferred && nt.After(clock.Today()) {
+		nt = weekdayDate(year-1, week, day)
+	}
+	return &nt
+}
+
+func weekdayDate(year, week, day int) time.Time {
+	t := time.Date(year, time.January, 7*(week-1), 0, 0, 0, 0, clock.Location())
 	weekDay := t.Weekday()
 	distance := day - int(weekDay)
-	nt := t.AddDate(0, 0, distance)
-	return &nt
+	return t.AddDate(0, 0, distance)
+}
+
+// parseYearCode 解析年份编码
+// 单字符：配置 YearCode 时为编码区间内的序号加 YearBase，否则为年份末位，取不晚于今年的最近年份
+// 两位：年份后两位，取不晚于明年的最近年份；四位：完整年份
+func parseYearCode(code string, rule BarCodeItem) (int, error) {
+	now := clock.Now()
+	switch len(code) {
+	case 1:
+		if len(rule.YearCode) > 1 {
+			if rule.YearBase == 0 {
+				return 0, errors.New("year_base is required with year_code")
+			}
+			index, err := parseIndexInCodeRange(code, rule.YearCode[0], rule.YearCode[1], rule.YearCodeReject...)
+			if err != nil {
+				return 0, err
+			}
+			return rule.YearBase + index - 1, nil
+		}
+		digit, err := strconv.Atoi(code)
+		if err != nil {
+			return 0, fmt.Errorf("invalid year code %s: %v", code, err)
+		}
+		return now.Year() - (now.Year()%10-digit+10)%10, nil
+	case 2:
+		yy, err := strconv.Atoi(code)
+		if err != nil {
+			return 0, fmt.Errorf("invalid year code %s: %v", code, err)
+		}
+		year := now.Year() - now.Year()%100 + yy
+		if year > now.Year()+1 {
+			year -= 100
+		}
+		return year, nil
+	case 4:
+		year, err := strconv.Atoi(code)
+		if err != nil {
+			return 0, fmt.Errorf("invalid year code %s: %v", code, err)
+		}
+		return year, nil
+	default:
+		return 0, fmt.Errorf("invalid year code %s", code)
+	}
+}
+
+// parseJulianDate 解析年内天数，code 末三位为天数（001-366），之前的字符为年份编码
+// 没有年份编码时使用 year，year 为0时按检测时间补全，晚于当天时为上一年
+func parseJulianDate(year int, code string, rule BarCodeItem) (*time.Time, error) {
+	if len(code) < 3 {
+		return nil, fmt.Errorf("invalid julian date %s", code)
+	}
+	days, err := strconv.Atoi(code[len(code)-3:])
+	if err != nil || days < 1 || days > 366 {
+		return nil, fmt.Errorf("invalid julian day %s", code[len(code)-3:])
+	}
+	if prefix := code[:len(code)-3]; prefix != "" {
+		if year, err = parseYearCode(prefix, rule); err != nil {
+			return nil, err
+		}
+	}
+
+	var inferred = year == 0
+	if inferred {
+		year = clock.Now().Year()
+	}
+	t := time.Date(year, time.January, days, 0, 0, 0, 0, clock.Location())
+	if t.Year() != year {
+		return nil, fmt.Errorf("julian day %v out of year %v", days, year)
+	}
+	if inferred && t.After(clock.Today()) {
+		t = time.Date(year-1, time.January, days, 0, 0, 0, 0, clock.Location())
+	}
+	return &t, nil
+}
+
+// parseSerial 解析流水号并校验取值范围
+func parseSerial(code string, rule BarCodeItem) (int64, error) {
+	var serial int64
+	if rule.Alphabet != "" {
+		// 字符集可以包含多字节字符，按字符而不是字节计算序号
+		alphabet := []rune(rule.Alphabet)
+		base := int64(len(alphabet))
+		for _, c := range code {
+			index := runeIndex(alphabet, c)
+			if index < 0 {
+				return 0, fmt.Errorf("serial %s contains %q not in alphabet %s", code, c, rule.Alphabet)
+			}
+			if serial > (math.MaxInt64-int64(index))/base {
+				return 0, fmt.Errorf("serial %s overflows int64", code)
+			}
+			serial = serial*base + int64(index)
+		}
+	} else {
+		radix := rule.Radix
+		if radix == 0 {
+			radix = 10
+		}
+		if radix < 2 || radix > 36 {
+			return 0, fmt.Errorf("invalid serial radix %v", radix)
+		}
+		// 以正负号开头的条码段不是合法的流水号
+		if strings.HasPrefix(code, "+") || strings.HasPrefix(code, "-") {
+			return 0, fmt.Errorf("invalid serial %s", code)
+		}
+		var err error
+		if serial, err = strconv.ParseInt(code, radix, 64); err != nil {
+			return 0, fmt.Errorf("parse serial %s with radix %v failed: %v", code, radix, err)
+		}
+	}
+
+	if rule.Min != nil && serial < *rule.Min {
+		return 0, fmt.Errorf("serial %v is less than %v", serial, *rule.Min)
+	}
+	if rule.Max != nil && serial > *rule.Max {
+		return 0, fmt.Errorf("serial %v is greater than %v", serial, *rule.Max)
+	}
+	return serial, nil
+}
+
+// runeIndex 返回字符在字符集中的序号，不存在时返回-1
+func runeIndex(alphabet []rune, c rune) int {
+	for idx, r := range alphabet {
+		if r == c {
+			return idx
+		}
+	}
+	return -1
+}
+
+// dateLayoutReplacer 将 YYYY、YY、MM、DD 格式转换为Go的时间格式
+var dateLayoutReplacer = strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "DD", "02")
+
+// parseLayoutDate 按格式解析完整日期，layout 为空时按长度使用 YYMMDD 或 YYYYMMDD
+func parseLayoutDate(code, layout string) (*time.Time, error) {
+	if layout == "" {
+		switch len(code) {
+		case 6:
+			layout = "YYMMDD"
+		case 8:
+			layout = "YYYYMMDD"
+		default:
+			return nil, fmt.Errorf("cannot infer date layout of %s", code)
+		}
+	}
+
+	t, err := time.ParseInLocation(dateLayoutReplacer.Replace(layout), code, clock.Location())
+	if err != nil {
+		return nil, fmt.Errorf("parse date %s with layout %s failed: %v", code, layout, err)
+	}
+	return &t, nil
 }
 
 func NewBarCodeDecoder(rule *BarCodeRule) *BarCodeDecoder {
 	var decoder BarCodeDecoder
+	decoder.Mode = BarCodeModePositional
+	if mode, ok := rule.Items["mode"].(string); ok && mode != "" {
+		decoder.Mode = strings.ToLower(mode)
+	}
+	switch decoder.Mode {
+	case BarCodeModeGS1:
+		decoder.BarCodeRule = rule
+		decoder.GS1 = decodeBarCodeGS1(rule.Items["gs1"])
+		return &decoder
+	case BarCodeModeRegex:
+		pattern, err := regexp.Compile(fmt.Sprint(rule.Items["pattern"]))
+		if err != nil {
+			log.Error("compile pattern of bar_code_rule %v failed: %v", rule.ID, err)
+			return nil
+		}
+		decoder.Pattern = pattern
+	case BarCodeModeDelimiter:
+		delimiter, ok := rule.Items["delimiter"].(string)
+		if !ok || delimiter == "" {
+			log.Error("delimiter of bar_code_rule %v is empty", rule.ID)
+			return nil
+		}
+		decoder.Delimiter = delimiter
+		if count := parseItemInt(rule.Items["field_count"]); count != nil {
+			decoder.FieldCount = int(*count)
+		}
+	}
+
 	itemsMapValue, ok := rule.Items["items"]
 	if !ok {
 		return nil
@@ -263,6 +639,7 @@ func NewBarCodeDecoder(rule *BarCodeRule) *BarCodeDecoder {
 
 	decoder.Rules = rules
 	decoder.BarCodeRule = rule
+	decoder.CheckDigit = decodeBarCodeCheckDigit(rule.Items["check_digit"])
 	return &decoder
 }
 
@@ -299,6 +676,40 @@ func DecodeBarCodeItemFromDBToStruct(item map[string]interface{}) BarCodeItem {
 		}
 		outItem.MonthCodeReject = monthCodeReject
 	}
+	if codes, ok := item["year_code"].([]interface{}); ok {
+		var yearCode []string
+		for _, code := range codes {
+			yearCode = append(yearCode, fmt.Sprint(code))
+		}
+		outItem.YearCode = yearCode
+	}
+	if codes, ok := item["year_code_reject"].([]interface{}); ok {
+		var yearCodeReject []string
+		for _, code := range codes {
+			yearCodeReject = append(yearCodeReject, fmt.Sprint(code))
+		}
+		outItem.YearCodeReject = yearCodeReject
+	}
+	if base, err := strconv.Atoi(fmt.Sprint(item["year_base"])); err == nil {
+		outItem.YearBase = base
+	}
+	if layout, ok := item["layout"].(string); ok {
+		outItem.Layout = layout
+	}
+	if radix, err := strconv.Atoi(fmt.Sprint(item["radix"])); err == nil {
+		outItem.Radix = radix
+	}
+	if alphabet, ok := item["alphabet"].(string); ok {
+		outItem.Alphabet = alphabet
+	}
+	outItem.Min = parseItemInt(item["min"])
+	outItem.Max = parseItemInt(item["max"])
+	if group, ok := item["group"].(string); ok {
+		outItem.Group = group
+	}
+	if field := parseItemInt(item["field"]); field != nil {
+		outItem.Field = int(*field)
+	}
 	if codes, ok := item["index_range"].([]interface{}); ok {
 		var indexRange []int
 		for _, c := range codes {
@@ -320,3 +731,23 @@ func DecodeBarCodeItemFromDBToStruct(item map[string]interface{}) BarCodeItem {
 
 	return outItem
 }
+
+// parseItemInt 解析解析项中的整数配置，为空或不是整数时返回nil
+func parseItemInt(value interface{}) *int64 {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return nil
+		}
+		n := int64(v)
+		return &n
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil
+		}
+		return &n
+	default:
+		return nil
+	}
+}
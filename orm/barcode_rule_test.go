@@ -0,0 +1,340 @@
+package orm
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+)
+
+// newTestDecoder 由 JSON 格式的解析项配置创建解析器，数字与数据库中读取的一样为 float64
+func newTestDecoder(t *testing.T, codeLength int, items string) *BarCodeDecoder {
+	t.Helper()
+	var config Map
+	if err := json.Unmarshal([]byte(items), &config); err != nil {
+		t.Fatal(err)
+	}
+	decoder := NewBarCodeDecoder(&BarCodeRule{CodeLength: codeLength, Items: config})
+	if decoder == nil {
+		t.Fatalf("create decoder of %s failed", items)
+	}
+	return decoder
+}
+
+// barCodeCase 条码及期望的解析结果，status 不为成功时不比较结果
+type barCodeCase struct {
+	code   string
+	status int
+	want   Map
+}
+
+func runBarCodeCases(t *testing.T, decoder *BarCodeDecoder, cases []barCodeCase) {
+	t.Helper()
+	for _, c := range cases {
+		out, status := decoder.Decode(c.code)
+		if status != c.status {
+			t.Errorf("decode %q status = %v, want %v (out %v)", c.code, status, c.status, out)
+			continue
+		}
+		if status != BarCodeStatusSuccess {
+			continue
+		}
+		for key, want := range c.want {
+			got := out[key]
+			if wt, ok := want.(time.Time); ok {
+				if gt, ok := got.(time.Time); !ok || !gt.Equal(wt) {
+					t.Errorf("decode %q %s = %v, want %v", c.code, key, got, wt)
+				}
+				continue
+			}
+			if got != want {
+				t.Errorf("decode %q %s = %v (%T), want %v (%T)", c.code, key, got, got, want, want)
+			}
+		}
+	}
+}
+
+// setTestClock 固定时钟为工厂时区的 2026-03-15 10:00
+func setTestClock(t *testing.T) {
+	t.Helper()
+	clock.Set(clock.NewFixedClock(time.Date(2026, 3, 15, 10, 0, 0, 0, clock.Location())))
+	t.Cleanup(func() { clock.Set(nil) })
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, clock.Location())
+}
+
+func TestDecodeDatetime(t *testing.T) {
+	setTestClock(t)
+
+	// 月码 1-9、A-C，日码 1-9、A-Y 剔除 B、I、O
+	decoder := newTestDecoder(t, 2, `{"items": [
+		{"key": "D", "type": "Datetime", "index_range": [1, 2],
+		 "month_code": ["1", "C"], "day_code": ["1", "Y"], "day_code_reject": ["B", "I", "O"]}
+	]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"3F", BarCodeStatusSuccess, Map{"D": date(2026, 3, 14)}},
+		{"CA", BarCodeStatusSuccess, Map{"D": date(2025, 12, 10)}},
+		// 晚于当天的日期为上一年
+		{"3H", BarCodeStatusSuccess, Map{"D": date(2025, 3, 16)}},
+		{"3B", BarCodeStatusIllegal, nil},
+		{"D1", BarCodeStatusIllegal, nil},
+	})
+
+	// 只有日码时按检测时间补全月份，晚于当天时为上一月
+	decoder = newTestDecoder(t, 1, `{"items": [
+		{"key": "D", "type": "Datetime", "index_range": [1],
+		 "day_code": ["1", "Y"], "day_code_reject": ["B", "I", "O"]}
+	]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"F", BarCodeStatusSuccess, Map{"D": date(2026, 3, 14)}},
+		{"G", BarCodeStatusSuccess, Map{"D": date(2026, 3, 15)}},
+		{"H", BarCodeStatusSuccess, Map{"D": date(2026, 2, 16)}},
+	})
+
+	// 以字母开头的编码区间不跳过数字与字母之间的字符
+	decoder = newTestDecoder(t, 2, `{"items": [
+		{"key": "D", "type": "Datetime", "index_range": [1, 2],
+		 "month_code": ["A", "L"], "day_code": ["1", "Y"], "day_code_reject": ["B", "I", "O"]}
+	]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"AA", BarCodeStatusSuccess, Map{"D": date(2026, 1, 10)}},
+		{"CF", BarCodeStatusSuccess, Map{"D": date(2026, 3, 14)}},
+		{"L1", BarCodeStatusSuccess, Map{"D": date(2025, 12, 1)}},
+		{"M1", BarCodeStatusIllegal, nil},
+		{"11", BarCodeStatusIllegal, nil},
+	})
+
+	// 相同 Key 的年份解析项给出年份时不再推算
+	decoder = newTestDecoder(t, 4, `{"items": [
+		{"key": "D", "type": "Year", "index_range": [1, 2]},
+		{"key": "D", "type": "Datetime", "index_range": [3, 4],
+		 "month_code": ["1", "C"], "day_code": ["1", "Y"], "day_code_reject": ["B", "I", "O"]}
+	]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"273H", BarCodeStatusSuccess, Map{"D": date(2027, 3, 16)}},
+		{"243H", BarCodeStatusSuccess, Map{"D": date(2024, 3, 16)}},
+	})
+}
+
+func TestDecodeWeekday(t *testing.T) {
+	setTestClock(t)
+
+	decoder := newTestDecoder(t, 3, `{"items": [{"key": "D", "type": "Weekday", "index_range": [1, 3]}]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		// 周内天数 1 为周日
+		{"114", BarCodeStatusSuccess, Map{"D": date(2026, 3, 11)}},
+		{"121", BarCodeStatusSuccess, Map{"D": date(2026, 3, 15)}},
+		// 晚于当天的日期为上一年
+		{"122", BarCodeStatusSuccess, Map{"D": date(2025, 3, 17)}},
+		{"1A1", BarCodeStatusIllegal, nil},
+	})
+}
+
+func TestDecodeYearAndJulian(t *testing.T) {
+	setTestClock(t)
+
+	decoder := newTestDecoder(t, 5, `{"items": [
+		{"key": "D", "type": "Year", "index_range": [1, 2]},
+		{"key": "D", "type": "Julian", "index_range": [3, 5]}
+	]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"26074", BarCodeStatusSuccess, Map{"D": date(2026, 3, 15)}},
+		{"24366", BarCodeStatusSuccess, Map{"D": date(2024, 12, 31)}},
+		{"25366", BarCodeStatusIllegal, nil},
+		{"26000", BarCodeStatusIllegal, nil},
+		{"2607A", BarCodeStatusIllegal, nil},
+	})
+
+	decoder = newTestDecoder(t, 3, `{"items": [{"key": "D", "type": "Julian", "index_range": [1, 3]}]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"074", BarCodeStatusSuccess, Map{"D": date(2026, 3, 15)}},
+		// 晚于当天的日期为上一年
+		{"100", BarCodeStatusSuccess, Map{"D": date(2025, 4, 10)}},
+	})
+
+	// 年份前缀：单字符为年份末位
+	decoder = newTestDecoder(t, 4, `{"items": [{"key": "D", "type": "Julian", "index_range": [1, 4]}]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"6074", BarCodeStatusSuccess, Map{"D": date(2026, 3, 15)}},
+		{"7001", BarCodeStatusSuccess, Map{"D": date(2017, 1, 1)}},
+	})
+}
+
+func TestDecodeYear(t *testing.T) {
+	setTestClock(t)
+
+	var cases = []struct {
+		items string
+		code  string
+		want  int
+		ok    bool
+	}{
+		{`{"key": "Y", "type": "Year", "index_range": [1]}`, "6", 2026, true},
+		{`{"key": "Y", "type": "Year", "index_range": [1]}`, "7", 2017, true},
+		{`{"key": "Y", "type": "Year", "index_range": [1]}`, "X", 0, false},
+		{`{"key": "Y", "type": "Year", "index_range": [1, 2]}`, "27", 2027, true},
+		{`{"key": "Y", "type": "Year", "index_range": [1, 2]}`, "28", 1928, true},
+		{`{"key": "Y", "type": "Year", "index_range": [1, 4]}`, "2031", 2031, true},
+		{`{"key": "Y", "type": "Year", "index_range": [1, 3]}`, "203", 0, false},
+		// 编码区间 A-Z 剔除 I、O，A 为 2010 年
+		{`{"key": "Y", "type": "Year", "index_range": [1], "year_code": ["A", "Z"], "year_code_reject": ["I", "O"], "year_base": 2010}`, "C", 2012, true},
+		{`{"key": "Y", "type": "Year", "index_range": [1], "year_code": ["A", "Z"], "year_code_reject": ["I", "O"], "year_base": 2010}`, "J", 2018, true},
+		{`{"key": "Y", "type": "Year", "index_range": [1], "year_code": ["A", "Z"], "year_code_reject": ["I", "O"], "year_base": 2010}`, "I", 0, false},
+		{`{"key": "Y", "type": "Year", "index_range": [1], "year_code": ["A", "Z"]}`, "C", 0, false},
+	}
+	for _, c := range cases {
+		decoder := newTestDecoder(t, len(c.code), `{"items": [`+c.items+`]}`)
+		status := BarCodeStatusIllegal
+		if c.ok {
+			status = BarCodeStatusSuccess
+		}
+		runBarCodeCases(t, decoder, []barCodeCase{{c.code, status, Map{"Y": c.want}}})
+	}
+}
+
+func TestDecodeFullDate(t *testing.T) {
+	setTestClock(t)
+
+	decoder := newTestDecoder(t, 6, `{"items": [{"key": "D", "type": "FullDate", "index_range": [1, 6]}]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"260301", BarCodeStatusSuccess, Map{"D": date(2026, 3, 1)}},
+		{"260230", BarCodeStatusIllegal, nil},
+		{"26031A", BarCodeStatusIllegal, nil},
+	})
+
+	decoder = newTestDecoder(t, 8, `{"items": [{"key": "D", "type": "FullDate", "index_range": [1, 8]}]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"20251231", BarCodeStatusSuccess, Map{"D": date(2025, 12, 31)}},
+		{"20251301", BarCodeStatusIllegal, nil},
+	})
+
+	decoder = newTestDecoder(t, 8, `{"items": [{"key": "D", "type": "FullDate", "index_range": [1, 8], "layout": "DDMMYYYY"}]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"01032026", BarCodeStatusSuccess, Map{"D": date(2026, 3, 1)}},
+		{"20260301", BarCodeStatusIllegal, nil},
+	})
+}
+
+func TestParseSerial(t *testing.T) {
+	min, max := int64(10), int64(999)
+	var cases = []struct {
+		item BarCodeItem
+		code string
+		want int64
+		ok   bool
+	}{
+		{BarCodeItem{}, "0042", 42, true},
+		{BarCodeItem{}, "4A", 0, false},
+		{BarCodeItem{}, "+42", 0, false},
+		{BarCodeItem{}, "-42", 0, false},
+		{BarCodeItem{Radix: 16}, "ff", 255, true},
+		{BarCodeItem{Radix: 16}, "FF", 255, true},
+		{BarCodeItem{Radix: 36}, "ZZ", 1295, true},
+		{BarCodeItem{Radix: 37}, "1", 0, false},
+		{BarCodeItem{}, "9223372036854775808", 0, false},
+		{BarCodeItem{Min: &min, Max: &max}, "010", 10, true},
+		{BarCodeItem{Min: &min, Max: &max}, "009", 0, false},
+		{BarCodeItem{Min: &min, Max: &max}, "1000", 0, false},
+		// 去除 I、O 的字符集
+		{BarCodeItem{Alphabet: "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ"}, "10", 34, true},
+		{BarCodeItem{Alphabet: "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ"}, "J", 18, true},
+		{BarCodeItem{Alphabet: "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ"}, "I", 0, false},
+		// 多字节字符集按字符计算序号及进制
+		{BarCodeItem{Alphabet: "〇一二三四五六七八九"}, "一二三", 123, true},
+		{BarCodeItem{Alphabet: "〇一二三四五六七八九"}, "九〇", 90, true},
+		{BarCodeItem{Alphabet: "〇一二三四五六七八九"}, "十", 0, false},
+		{BarCodeItem{Alphabet: "01"}, "1111111111111111111111111111111111111111111111111111111111111111", 0, false},
+	}
+	for _, c := range cases {
+		got, err := parseSerial(c.code, c.item)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("parseSerial(%q, %+v) = (%v, %v), want %v ok %v", c.code, c.item, got, err, c.want, c.ok)
+		}
+	}
+
+	// 按解析项配置解析流水号并校验取值范围
+	decoder := newTestDecoder(t, 6, `{"items": [
+		{"key": "S", "type": "Serial", "index_range": [3, 6], "radix": 16, "min": 16, "max": 4095},
+		{"key": "L", "type": "Category", "index_range": [1, 2], "category_set": ["L1", "L2"]}
+	]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"L100ff", BarCodeStatusSuccess, Map{"S": int64(255), "L": "L1"}},
+		{"L20010", BarCodeStatusSuccess, Map{"S": int64(16), "L": "L2"}},
+		{"L1000f", BarCodeStatusIllegal, nil},
+		{"L11000", BarCodeStatusIllegal, nil},
+		{"L300ff", BarCodeStatusIllegal, nil},
+		{"L100f", BarCodeStatusTooShort, nil},
+	})
+}
+
+func TestDecodeRegex(t *testing.T) {
+	setTestClock(t)
+
+	decoder := newTestDecoder(t, 0, `{"mode": "regex",
+		"pattern": "^(?P<LINE>L\\d)-(?P<DATE>\\d{6})-(?P<SN>[0-9A-F]+)(?:-(?P<OPT>\\w+))?$",
+		"items": [
+			{"key": "Line", "type": "Category", "group": "LINE", "category_set": ["L1", "L2"]},
+			{"key": "Date", "type": "FullDate", "group": "DATE"},
+			{"key": "Year", "type": "Year", "group": "DATE", "index_range": [1, 2]},
+			{"key": "Serial", "type": "Serial", "group": "SN", "radix": 16},
+			{"key": "Option", "type": "Category", "group": "OPT"}
+		]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"L1-260301-00FF", BarCodeStatusSuccess, Map{"Line": "L1", "Date": date(2026, 3, 1), "Year": 2026, "Serial": int64(255)}},
+		{"L2-251231-1A-X7", BarCodeStatusSuccess, Map{"Line": "L2", "Date": date(2025, 12, 31), "Serial": int64(26), "Option": "X7"}},
+		{"L3-260301-00FF", BarCodeStatusIllegal, nil},
+		{"L1-261301-00FF", BarCodeStatusIllegal, nil},
+		{"X1-260301-00FF", BarCodeStatusIllegal, nil},
+		{"L1-260301-00FG", BarCodeStatusIllegal, nil},
+	})
+	// 未匹配的可选分组跳过对应的解析项
+	if out, _ := decoder.Decode("L1-260301-00FF"); out["Option"] != nil {
+		t.Errorf("option = %v, want skipped", out["Option"])
+	}
+
+	if NewBarCodeDecoder(&BarCodeRule{Items: Map{"mode": "regex", "pattern": "(", "items": []interface{}{}}}) != nil {
+		t.Error("decoder with invalid pattern should be nil")
+	}
+}
+
+func TestDecodeDelimiter(t *testing.T) {
+	setTestClock(t)
+
+	decoder := newTestDecoder(t, 0, `{"mode": "delimiter", "delimiter": "|", "field_count": 3,
+		"check_digit": {"algorithm": "luhn", "index_range": [1, 1], "check_index": 2},
+		"items": [
+			{"key": "Line", "type": "Category", "field": 1},
+			{"key": "Serial", "type": "Serial", "field": 2},
+			{"key": "Date", "type": "FullDate", "field": 3},
+			{"key": "Month", "type": "Category", "field": 3, "index_range": [3, 4]}
+		]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		// Luhn("1") = 8
+		{"18|0042|260301", BarCodeStatusSuccess, Map{"Line": "18", "Serial": int64(42), "Date": date(2026, 3, 1), "Month": "03"}},
+		{"18|0042", BarCodeStatusTooShort, nil},
+		{"18|0042|260301|X", BarCodeStatusTooShort, nil},
+		{"18|42A|260301", BarCodeStatusIllegal, nil},
+		{"19|0042|260301", BarCodeStatusCheckFail, nil},
+	})
+
+	// 不校验字段数量时，缺少的字段跳过对应的解析项
+	decoder = newTestDecoder(t, 0, `{"mode": "delimiter", "delimiter": ";;",
+		"items": [
+			{"key": "Line", "type": "Category", "field": 1},
+			{"key": "Serial", "type": "Serial", "field": 2}
+		]}`)
+	runBarCodeCases(t, decoder, []barCodeCase{
+		{"L1;;7", BarCodeStatusSuccess, Map{"Line": "L1", "Serial": int64(7)}},
+		{"L1", BarCodeStatusSuccess, Map{"Line": "L1"}},
+	})
+	if out, _ := decoder.Decode("L1"); out["Serial"] != nil {
+		t.Errorf("serial = %v, want skipped", out["Serial"])
+	}
+
+	if NewBarCodeDecoder(&BarCodeRule{Items: Map{"mode": "delimiter", "items": []interface{}{}}}) != nil {
+		t.Error("decoder without delimiter should be nil")
+	}
+}
@@ -0,0 +1,61 @@
+package orm
+
+import (
+	"fmt"
+	"time"
+)
+
+// ControlViolation 控制图判异规则的违规事件
+type ControlViolation struct {
+	ID                uint      `gorm:"column:id;primary_key"`
+	MaterialVersionID uint      `gorm:"COMMENT:'料号版本ID';not null;index"`
+	DeviceID          uint      `gorm:"COMMENT:'检测设备ID';not null;index"`
+	ProductID         uint      `gorm:"COMMENT:'触发规则的产品ID';not null"`
+	PointName         string    `gorm:"COMMENT:'点位名称';not null"`
+	Rule              int       `gorm:"COMMENT:'判异规则编号';not null"`
+	Description       string    `gorm:"COMMENT:'判异规则描述'"`
+	Value             float64   `gorm:"COMMENT:'检测值'"`
+	CenterLine        float64   `gorm:"COMMENT:'中心线'"`
+	UCL               float64   `gorm:"COMMENT:'控制上限';column:ucl"`
+	LCL               float64   `gorm:"COMMENT:'控制下限';column:lcl"`
+	CreatedAt         time.Time `gorm:"COMMENT:'触发时间';index"`
+}
+
+// ControlViolationQuery 违规事件查询条件，零值时不做过滤
+type ControlViolationQuery struct {
+	MaterialVersionID uint
+	DeviceID          uint
+	PointName         string
+	Begin             time.Time
+	End               time.Time
+	Limit             int
+}
+
+// FindControlViolations 按触发时间倒序查询违规事件
+func FindControlViolations(q ControlViolationQuery) ([]ControlViolation, error) {
+	query := DB.Model(&ControlViolation{})
+	if q.MaterialVersionID != 0 {
+		query = query.Where("material_version_id = ?", q.MaterialVersionID)
+	}
+	if q.DeviceID != 0 {
+		query = query.Where("device_id = ?", q.DeviceID)
+	}
+	if q.PointName != "" {
+		query = query.Where("point_name = ?", q.PointName)
+	}
+	if !q.Begin.IsZero() {
+		query = query.Where("created_at >= ?", q.Begin)
+	}
+	if !q.End.IsZero() {
+		query = query.Where("created_at < ?", q.End)
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	var violations []ControlViolation
+	if err := query.Order("created_at desc").Find(&violations).Error; err != nil {
+		return nil, fmt.Errorf("find control_violations failed: %v", err)
+	}
+	return violations, nil
+}
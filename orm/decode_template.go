@@ -14,5 +14,5 @@ type DecodeTemplate struct {
 	CreatedAtColumnIndex int  `gorm:"not null"` // 检测时间位置
 	BarCodeIndex         int  // 编码读取位置
 	BarCodeRuleID        uint `gorm:"COMMENT:'编码规则ID';column:bar_code_rule_id"`
-	ProductColumns       Map  `gorm:"type:JSON;not null"`
+	ProductColumns       Map  `gorm:"not null"`
 }
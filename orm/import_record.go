@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/SasukeBo/log"
 	"github.com/SasukeBo/pmes-data-producer/cache"
+	"github.com/SasukeBo/pmes-data-producer/clock"
 	"github.com/jinzhu/copier"
 	"github.com/jinzhu/gorm"
 	"time"
@@ -23,27 +24,26 @@ const (
 )
 
 func nowDateStr() string {
-	var tStr = time.Now().String()
-	return tStr[:10]
+	return clock.Now().Format("2006-01-02")
 }
 
-func (i *ImportRecord) genKey(deviceID uint) string {
-	return fmt.Sprintf("device_realtime_key_%v_%s", deviceID, nowDateStr())
+func (i *ImportRecord) genKey(deviceID uint, period ShiftPeriod) string {
+	return fmt.Sprintf("device_realtime_key_%v_%s", deviceID, period.Start.Format("2006-01-02_15:04"))
 }
 
 type ImportStatus string
 
 type ImportRecord struct {
 	gorm.Model
-	FileID             uint         `gorm:"column:file_id"'` // 关联文件的ID
-	FileName           string       `gorm:"not null"`        // 文件名称
-	Path               string       `gorm:"not null"`        // 存储路径
-	MaterialID         uint         `gorm:"not null;index"`  // 关联料号ID
-	DeviceID           uint         `gorm:"not null;index"`  // 关联设备ID
+	FileID             uint         `gorm:"column:file_id"` // 关联文件的ID
+	FileName           string       `gorm:"not null"`       // 文件名称
+	Path               string       `gorm:"not null"`       // 存储路径
+	MaterialID         uint         `gorm:"not null;index"` // 关联料号ID
+	DeviceID           uint         `gorm:"not null;index"` // 关联设备ID
 	RowCount           int          // 数据行数
 	RowFinishedCount   int          // 完成行数
 	RowInvalidCount    int          // 无效数据行
-	Status             ImportStatus `gorm:"not null;default:false"` // 导入状态
+	Status             ImportStatus `gorm:"not null;default:'Loading'"` // 导入状态
 	ErrorCode          string       // 错误码
 	OriginErrorMessage string       // 原始错误信息
 	FileSize           int
@@ -53,15 +53,23 @@ type ImportRecord struct {
 	MaterialVersionID  uint    `gorm:"not null;default: 0"`       // 料号版本信息
 	Blocked            bool    `gorm:"default:false"`             // 屏蔽导入的数据
 	Yield              float64 // 单次导入记录的良率
+	QualifiedCount     int     `gorm:"not null;default:0"` // 完成行中合格的数量
+	Shift              string  // 实时导入记录所属的班次
 }
 
-// 获取实时设备的导入记录
-// 生成以当前时间日期为结尾的key，通过key缓存获取数据
-// 当缓存中没有该日期的实时导入记录时，从数据库获取
-// 当数据库中没有该日期的实时导入记录时，创建新纪录
-func (i *ImportRecord) GetDeviceRealtimeRecord(device *Device) error {
+// 获取实时设备在检测时间所在班次的导入记录
+// 实时导入记录按班次分段，生成以班次开始时间为结尾的key，通过key缓存获取数据
+// 当缓存中没有该班次的实时导入记录时，从数据库获取
+// 当数据库中没有该班次的实时导入记录时，创建新纪录
+// 检测时间位于之前的班次时（设备离线缓存后上传），见 getPastRealtimeRecord
+func (i *ImportRecord) GetDeviceRealtimeRecord(device *Device, at time.Time) error {
+	period := CurrentShiftPeriod(clock.Now())
+	if past := CurrentShiftPeriod(at); past.Start.Before(period.Start) {
+		return i.getPastRealtimeRecord(device, past)
+	}
+
 	// 生成key
-	cacheKey := i.genKey(device.ID)
+	cacheKey := i.genKey(device.ID, period)
 
 	// 获取缓存
 	cacheValue := cache.Get(cacheKey)
@@ -77,14 +85,9 @@ func (i *ImportRecord) GetDeviceRealtimeRecord(device *Device) error {
 		}
 	}
 
-	// 查询数据库 [当前设备的 实时导入的 当前日期的 正在导入的] 导入记录
-	var query = "device_id = ? AND import_type = ? AND DATE(created_at) = DATE(?) AND import_records.status = ?"
-
-	var record ImportRecord
-	if err := DB.Model(&ImportRecord{}).Where(
-		query, device.ID, ImportRecordTypeRealtime, time.Now(), ImportStatusImporting,
-	).Find(&record).Error; err == nil {
-		if err := copier.Copy(i, &record); err == nil {
+	// 查询数据库 [当前设备的 实时导入的 当前班次的 正在导入的] 导入记录
+	if record, err := findRealtimeRecord(device.ID, period, ImportStatusImporting); err == nil {
+		if err := copier.Copy(i, record); err == nil {
 			if err := cache.Set(cacheKey, *i); err != nil {
 				log.Error("cache import record failed: %v", err)
 			}
@@ -92,22 +95,48 @@ func (i *ImportRecord) GetDeviceRealtimeRecord(device *Device) error {
 		}
 	}
 
-	// 如果没有找到，更新当前版本的总数及良率统计
-	{
-		var lastRealtimeRecord ImportRecord
-		if err := DB.Model(&ImportRecord{}).Where(
-			query, device.ID, ImportRecordTypeRealtime, time.Now().AddDate(0, 0, -1), ImportStatusImporting,
-		).Find(&lastRealtimeRecord).Error; err == nil {
-			lastRealtimeRecord.Status = ImportStatusFinished
-			_ = DB.Save(&lastRealtimeRecord)
+	// 如果没有找到，关闭该设备之前班次的实时导入记录，更新版本的总数及良率统计
+	if _, err := closeStaleRealtimeRecords(period.Start, device.ID); err != nil {
+		log.Errorln(err)
+	}
 
-			var version MaterialVersion
-			if err := version.Get(lastRealtimeRecord.MaterialVersionID); err == nil {
-				_ = version.UpdateWithRecord(&lastRealtimeRecord)
-			}
+	if err := i.createRealtimeRecord(device, period, false); err != nil {
+		return err
+	}
+
+	if err := cache.Set(cacheKey, *i); err != nil {
+		log.Error("cache import record failed: %v", err)
+	}
+	return nil
+}
+
+// getPastRealtimeRecord 获取之前班次的实时导入记录，不使用缓存
+// 优先使用该班次导入中的记录，其次使用已完成的记录，都没有时创建该班次的记录，由日切任务关闭
+func (i *ImportRecord) getPastRealtimeRecord(device *Device, period ShiftPeriod) error {
+	for _, status := range []ImportStatus{ImportStatusImporting, ImportStatusFinished} {
+		if record, err := findRealtimeRecord(device.ID, period, status); err == nil {
+			return copier.Copy(i, record)
 		}
 	}
 
+	return i.createRealtimeRecord(device, period, true)
+}
+
+// findRealtimeRecord 查询设备在班次内创建的指定状态的实时导入记录
+func findRealtimeRecord(deviceID uint, period ShiftPeriod, status ImportStatus) (*ImportRecord, error) {
+	var query = "device_id = ? AND import_type = ? AND created_at >= ? AND created_at < ? AND import_records.status = ?"
+
+	var record ImportRecord
+	if err := DB.Model(&ImportRecord{}).Where(
+		query, deviceID, ImportRecordTypeRealtime, period.Start, period.End, status,
+	).Find(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// createRealtimeRecord 创建班次的实时导入记录，backdate 为 true 时创建时间为班次开始时间
+func (i *ImportRecord) createRealtimeRecord(device *Device, period ShiftPeriod, backdate bool) error {
 	// 获取料号的当前版本信息
 	var version MaterialVersion
 	if err := version.GetActiveWithMaterialID(device.MaterialID); err != nil {
@@ -121,31 +150,138 @@ func (i *ImportRecord) GetDeviceRealtimeRecord(device *Device) error {
 	i.Path = "realtime"
 	i.ImportType = ImportRecordTypeRealtime
 	i.MaterialVersionID = version.ID
-	if err := DB.Create(i).Error; err != nil {
-		return err
+	i.Shift = period.Name
+	if backdate {
+		i.CreatedAt = period.Start
 	}
+	return DB.Create(i).Error
+}
 
-	if err := cache.Set(cacheKey, *i); err != nil {
-		log.Error("cache import record failed: %v", err)
+func (i *ImportRecord) Increase(tc, fc int, qualified bool) error {
+	var qc int
+	if qualified {
+		qc = fc
 	}
-	return nil
+	return i.IncreaseCount(tc, fc, qc)
 }
 
-func (i *ImportRecord) Increase(tc, fc int, qualified bool) error {
+// IncreaseCount 累加导入记录的统计数量
+// tc 为数据行数，fc 为完成行数，qc 为完成行中合格的数量
+// 计数在数据库中原子累加，良率由累加后的合格数及完成行数计算，
+// 并发写入同一记录时不会丢失计数；内存中的计数仅同步本次的增量
+// 记录已完成时同时累加到料号版本
+func (i *ImportRecord) IncreaseCount(tc, fc, qc int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return i.IncreaseCountTx(tx, tc, fc, qc)
+	})
+}
+
+// IncreaseCountTx 在事务中累加导入记录的统计数量，见 IncreaseCount，tx 必须为事务
+// 累加前锁定记录，与 finish 串行执行：finish 先提交时读到完成状态，本次增量补充累加到版本；
+// 否则 finish 等待本事务提交后重新读取计数，其中已包含本次增量，不会重复累加
+func (i *ImportRecord) IncreaseCountTx(tx *gorm.DB, tc, fc, qc int) error {
 	if i == nil {
 		return errors.New("cannot increase nil import record")
 	}
+
+	// 缓存的记录可能已被日切关闭，以数据库中的状态为准
+	var current ImportRecord
+	if err := forUpdate(tx).Select("status").Where("id = ?", i.ID).First(&current).Error; err != nil {
+		return fmt.Errorf("lock import_record %v failed: %v", i.ID, err)
+	}
+	i.Status = current.Status
+
+	err := tx.Model(&ImportRecord{}).Where("id = ?", i.ID).UpdateColumns(map[string]interface{}{
+		"row_count":          gorm.Expr("row_count + ?", tc),
+		"row_finished_count": gorm.Expr("row_finished_count + ?", fc),
+		"qualified_count":    gorm.Expr("qualified_count + ?", qc),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("increase import_record %v failed: %v", i.ID, err)
+	}
+	err = tx.Model(&ImportRecord{}).Where("id = ? AND row_finished_count > 0", i.ID).UpdateColumn(
+		"yield", gorm.Expr("1.0 * qualified_count / row_finished_count"),
+	).Error
+	if err != nil {
+		return fmt.Errorf("update yield of import_record %v failed: %v", i.ID, err)
+	}
+
+	// 已完成的记录已累加到料号版本，补充累加本次的增量
+	if i.Status == ImportStatusFinished {
+		var version = MaterialVersion{Model: gorm.Model{ID: i.MaterialVersionID}}
+		if err := version.increase(tx, fc, qc); err != nil {
+			return err
+		}
+	}
+
 	i.RowCount = i.RowCount + tc
-	ok := float64(i.RowFinishedCount) * i.Yield
 	i.RowFinishedCount = i.RowFinishedCount + fc
-	if qualified {
-		ok = ok + float64(fc)
-		i.Yield = ok / float64(i.RowFinishedCount)
+	i.QualifiedCount = i.QualifiedCount + qc
+	if i.RowFinishedCount > 0 {
+		i.Yield = float64(i.QualifiedCount) / float64(i.RowFinishedCount)
 	}
-	cacheKey := i.genKey(i.DeviceID)
-	if err := cache.Set(cacheKey, *i); err != nil {
-		log.Error("cache import record failed: %v", err)
+	return nil
+}
+
+// CloseStaleRealtimeRecords 关闭所有在 before 之前创建且仍在导入中的实时导入记录，返回本次关闭的数量
+func CloseStaleRealtimeRecords(before time.Time) (int, error) {
+	return closeStaleRealtimeRecords(before, 0)
+}
+
+// closeStaleRealtimeRecords 关闭在 before 之前创建的实时导入记录，deviceID 不为0时只关闭该设备的记录
+func closeStaleRealtimeRecords(before time.Time, deviceID uint) (int, error) {
+	query := DB.Model(&ImportRecord{}).Where(
+		"import_type = ? AND status = ? AND created_at < ?", ImportRecordTypeRealtime, ImportStatusImporting, before,
+	)
+	if deviceID != 0 {
+		query = query.Where("device_id = ?", deviceID)
+	}
+	var records []ImportRecord
+	if err := query.Find(&records).Error; err != nil {
+		return 0, fmt.Errorf("find stale realtime import_records failed: %v", err)
+	}
+
+	var closed int
+	for idx := range records {
+		ok, err := records[idx].finish()
+		if err != nil {
+			log.Errorln(err)
+			continue
+		}
+		if ok {
+			closed++
+		}
 	}
+	return closed, nil
+}
+
+// finish 将导入中的记录标记为完成，并将计数累加到料号版本
+// 通过带状态条件的更新抢占记录，多个实例同时关闭同一记录时只有一个实例会累加，
+// 已被其他实例关闭时返回false
+// 关闭及累加在同一事务中，并发累加计数的写入等待事务结束后按完成状态累加到版本
+func (i *ImportRecord) finish() (bool, error) {
+	var finished bool
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&ImportRecord{}).Where("id = ? AND status = ?", i.ID, ImportStatusImporting).UpdateColumn(
+			"status", ImportStatusFinished,
+		)
+		if result.Error != nil {
+			return fmt.Errorf("finish import_record %v failed: %v", i.ID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
 
-	return DB.Save(i).Error
+		// 重新读取关闭时的计数
+		if err := tx.Model(i).Where("id = ?", i.ID).First(i).Error; err != nil {
+			return fmt.Errorf("reload import_record %v failed: %v", i.ID, err)
+		}
+		var version = MaterialVersion{Model: gorm.Model{ID: i.MaterialVersionID}}
+		if err := version.increase(tx, i.RowFinishedCount, i.QualifiedCount); err != nil {
+			return err
+		}
+		finished = true
+		return nil
+	})
+	return finished, err
 }
@@ -0,0 +1,18 @@
+package orm
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB 在临时目录中创建 SQLite 数据库，target 不为0时只迁移到该版本
+func openTestDB(t *testing.T, target uint) {
+	t.Helper()
+	if _, err := Open(Options{Driver: DialectSQLite, Name: filepath.Join(t.TempDir(), "test.db")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = Close() })
+	if _, err := MigrateUp(target); err != nil {
+		t.Fatal(err)
+	}
+}
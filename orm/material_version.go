@@ -9,13 +9,14 @@ import (
 // MaterialVersion 材料版本号
 type MaterialVersion struct {
 	gorm.Model
-	Version     string `gorm:"not null"`
-	Description string
-	MaterialID  uint `gorm:"not null"`
-	Active      bool `gorm:"default:false"`
-	UserID      uint
-	Amount      int
-	Yield       float64
+	Version        string `gorm:"not null"`
+	Description    string
+	MaterialID     uint `gorm:"not null"`
+	Active         bool `gorm:"default:false"`
+	UserID         uint
+	Amount         int
+	Yield          float64
+	QualifiedCount int `gorm:"not null;default:0"` // 合格数量，Yield = QualifiedCount / Amount
 }
 
 func (mv *MaterialVersion) Get(id uint) error {
@@ -25,46 +26,58 @@ func (mv *MaterialVersion) Get(id uint) error {
 
 	return nil
 }
+
+// UpdateWithRecord 根据导入记录更新版本的总数及良率
+// 导入完成时累加记录的完成行数及合格数，撤销导入时扣除
 func (mv *MaterialVersion) UpdateWithRecord(record *ImportRecord) error {
 	if mv == nil {
 		return errors.New("cannot update <nil> version")
 	}
 
+	var sign int
 	switch record.Status {
 	case ImportStatusFinished:
-		currentTotal := mv.Amount
-		total := currentTotal + record.RowFinishedCount
-		mv.Amount = total
-
-		importOK := int(float64(record.RowFinishedCount) * record.Yield)
-		currentOK := int(float64(currentTotal) * mv.Yield)
-		currentOK = currentOK + importOK
-
-		if total == 0 {
-			mv.Yield = 0
-		} else {
-			mv.Yield = float64(currentOK) / float64(total)
-		}
-		return DB.Save(mv).Error
-
+		sign = 1
 	case ImportStatusReverted:
-		currentTotal := mv.Amount
-		currentOK := int(float64(currentTotal) * mv.Yield)
+		sign = -1
+	default:
+		return nil
+	}
 
-		recordOK := int(float64(record.RowFinishedCount) * record.Yield)
+	return mv.increase(DB, sign*record.RowFinishedCount, sign*record.QualifiedCount)
+}
 
-		total := currentTotal - record.RowFinishedCount
-		ok := currentOK - recordOK
-		mv.Amount = total
+// increase 原子累加版本的总数及合格数，并重新计算良率，db 可以为事务
+func (mv *MaterialVersion) increase(db *gorm.DB, amount, qualified int) error {
+	err := db.Model(&MaterialVersion{}).Where("id = ?", mv.ID).UpdateColumns(map[string]interface{}{
+		"amount":          gorm.Expr("amount + ?", amount),
+		"qualified_count": gorm.Expr("qualified_count + ?", qualified),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("update amount of material_version %v failed: %v", mv.ID, err)
+	}
+	if err := updateVersionYield(db, "id = ?", mv.ID); err != nil {
+		return err
+	}
 
-		if total == 0 {
-			mv.Yield = 0
-		} else {
-			mv.Yield = float64(ok) / float64(total)
-		}
-		return DB.Save(mv).Error
+	mv.Amount = mv.Amount + amount
+	mv.QualifiedCount = mv.QualifiedCount + qualified
+	if mv.Amount > 0 {
+		mv.Yield = float64(mv.QualifiedCount) / float64(mv.Amount)
+	} else {
+		mv.Yield = 0
 	}
+	return nil
+}
 
+// updateVersionYield 由合格数及总数重新计算版本良率
+func updateVersionYield(db *gorm.DB, where string, args ...interface{}) error {
+	err := db.Model(&MaterialVersion{}).Where(where, args...).UpdateColumn("yield", gorm.Expr(
+		"CASE WHEN amount > 0 THEN 1.0 * qualified_count / amount ELSE 0 END",
+	)).Error
+	if err != nil {
+		return fmt.Errorf("update yield of material_versions failed: %v", err)
+	}
 	return nil
 }
 
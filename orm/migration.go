@@ -0,0 +1,252 @@
+package orm
+
+import (
+	"fmt"
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// Migration 数据库版本迁移
+// MySQL 的 DDL 语句会隐式提交事务，迁移中途失败时已执行的表结构变更不会回滚，
+// 因此每个迁移的 Up、Down 都需要可以重复执行：已存在的表、字段、索引不再创建，不存在的不再删除，
+// 排除失败原因后重新执行迁移即可，不需要手工修复表结构
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration 已执行的迁移版本
+type SchemaMigration struct {
+	Version   uint   `gorm:"primary_key;auto_increment:false"`
+	Name      string `gorm:"not null"`
+	AppliedAt time.Time
+}
+
+// MigrationStatus 迁移版本及是否已执行
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// realtimeRecordIndex 实时导入记录查询（设备、导入方式、状态、创建时间范围）使用的复合索引
+const realtimeRecordIndex = "idx_import_records_realtime"
+
+// migrations 按版本号递增排列，已发布的迁移不可修改，变更表结构时追加新的迁移
+// 迁移只使用 migration_schema.go 中的表结构快照，不使用业务模型，模型变更不影响已发布的迁移
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_base_tables",
+		Up: func(tx *gorm.DB) error {
+			// 表已由其他服务创建时补充缺少的字段及索引
+			return tx.AutoMigrate(
+				&deviceV1{}, &materialVersionV1{}, &barCodeRuleV1{}, &decodeTemplateV1{}, &importRecordV1{}, &productV1{},
+			).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			// devices、material_versions 等表由 PMES 服务维护，数据与其共享，不随本服务回滚删除
+			return fmt.Errorf("tables of version 1 are shared with PMES service and can not be dropped, drop them manually if necessary")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_spc_tables",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&pointSpecV2{}, &spcStatV2{}, &controlViolationV2{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&controlViolationV2{}, &spcStatV2{}, &pointSpecV2{}).Error
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_realtime_record_index",
+		Up: func(tx *gorm.DB) error {
+			return tx.Table("import_records").AddIndex(
+				realtimeRecordIndex, "device_id", "import_type", "status", "created_at",
+			).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return removeIndex(tx, "import_records", realtimeRecordIndex)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_product_message_id",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&productV4{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := removeIndex(tx, "products", "uidx_device_message_id"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "products", "message_id")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_product_judgements",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&productV5{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "products", "point_values_invalid", "point_judgements", "qualified_mismatch")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_qualified_counts",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&importRecordV6{}, &materialVersionV6{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, "import_records", "qualified_count"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "material_versions", "qualified_count")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_import_record_shift",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&importRecordV7{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "import_records", "shift")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "seed_qualified_counts",
+		Up: func(tx *gorm.DB) error {
+			// 已有数据的合格数由良率推算，保证累加计数后良率延续原值，精确值可由 backfill 命令按产品表重新计算
+			err := tx.Exec(`UPDATE import_records SET qualified_count = ROUND(yield * row_finished_count)
+				WHERE qualified_count = 0 AND yield > 0`).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec(`UPDATE material_versions SET qualified_count = ROUND(yield * amount)
+				WHERE qualified_count = 0 AND yield > 0`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			// 推算的合格数与良率一致，回滚时保留
+			return nil
+		},
+	},
+}
+
+// removeIndex 删除表中的索引，索引不存在时忽略
+func removeIndex(tx *gorm.DB, table string, index string) error {
+	if !tx.Dialect().HasIndex(table, index) {
+		return nil
+	}
+	return tx.Table(table).RemoveIndex(index).Error
+}
+
+// dropColumns 删除表中的字段，字段不存在时忽略，SQLite 不支持删除字段，返回错误
+func dropColumns(tx *gorm.DB, table string, columns ...string) error {
+	if tx.Dialect().GetName() == DialectSQLite {
+		return fmt.Errorf("sqlite does not support dropping columns of table %s", table)
+	}
+	for _, column := range columns {
+		if !tx.Dialect().HasColumn(table, column) {
+			continue
+		}
+		if err := tx.Table(table).DropColumn(column).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appliedMigrations 返回已执行的迁移版本
+func appliedMigrations() (map[uint]SchemaMigration, error) {
+	if err := DB.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, fmt.Errorf("create schema_migrations failed: %v", err)
+	}
+	var records []SchemaMigration
+	if err := DB.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("find schema_migrations failed: %v", err)
+	}
+	var applied = make(map[uint]SchemaMigration)
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// MigrateUp 按版本顺序执行未执行的迁移，target 不为0时只执行到该版本，返回本次执行的迁移
+func MigrateUp(target uint) ([]Migration, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if target != 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: clock.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate up %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown 按版本倒序回滚最近执行的 steps 个迁移，返回本次回滚的迁移
+func MigrateDown(steps int) ([]Migration, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Where("version = ?", m.Version).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate down %d_%s failed: %v", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Migrations 返回所有迁移的执行状态
+func Migrations() ([]MigrationStatus, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		record, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{Migration: m, Applied: ok, AppliedAt: record.AppliedAt})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,200 @@
+package orm
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// 迁移使用的表结构快照，与执行迁移时的表结构一致，模型增加字段时不修改快照，在新的迁移中增加快照
+
+// deviceV1 设备表，由其他服务创建及维护
+type deviceV1 struct {
+	gorm.Model
+	UUID           string `gorm:"column:uuid;unique_index;not null"`
+	Name           string `gorm:"not null"`
+	Remark         string `gorm:"not null;unique_index:uidx_name_material_id"`
+	IP             string `gorm:"column:ip;"`
+	MaterialID     uint   `gorm:"column:material_id;not null;unique_index:uidx_name_material_id"`
+	DeviceSupplier string
+	IsRealtime     bool `gorm:"default:false;not null"`
+	Address        string
+}
+
+func (deviceV1) TableName() string { return "devices" }
+
+// materialVersionV1 料号版本表，由其他服务创建及维护
+type materialVersionV1 struct {
+	gorm.Model
+	Version     string `gorm:"not null"`
+	Description string
+	MaterialID  uint `gorm:"not null"`
+	Active      bool `gorm:"default:false"`
+	UserID      uint
+	Amount      int
+	Yield       float64
+}
+
+func (materialVersionV1) TableName() string { return "material_versions" }
+
+// barCodeRuleV1 编码规则表，由其他服务创建及维护
+type barCodeRuleV1 struct {
+	gorm.Model
+	CodeLength int    `gorm:"COMMENT:'编码长度';not null"`
+	Name       string `gorm:"COMMENT:'编码规则名称';not null;unique_index"`
+	Remark     string `gorm:"COMMENT:'编码规则描述';not null"`
+	UserID     uint   `gorm:"COMMENT:'编码规则创建人'"`
+	Items      Map    `gorm:"COMMENT:'解析项配置';not null"`
+}
+
+func (barCodeRuleV1) TableName() string { return "bar_code_rules" }
+
+// decodeTemplateV1 文件解析模板表，由其他服务创建及维护
+type decodeTemplateV1 struct {
+	gorm.Model
+	MaterialID           uint `gorm:"not null"`
+	MaterialVersionID    uint `gorm:"not null"`
+	UserID               uint
+	DataRowIndex         int
+	CreatedAtColumnIndex int `gorm:"not null"`
+	BarCodeIndex         int
+	BarCodeRuleID        uint `gorm:"COMMENT:'编码规则ID';column:bar_code_rule_id"`
+	ProductColumns       Map  `gorm:"not null"`
+}
+
+func (decodeTemplateV1) TableName() string { return "decode_templates" }
+
+// importRecordV1 导入记录表
+type importRecordV1 struct {
+	gorm.Model
+	FileID             uint   `gorm:"column:file_id"`
+	FileName           string `gorm:"not null"`
+	Path               string `gorm:"not null"`
+	MaterialID         uint   `gorm:"not null;index"`
+	DeviceID           uint   `gorm:"not null;index"`
+	RowCount           int
+	RowFinishedCount   int
+	RowInvalidCount    int
+	Status             string `gorm:"not null;default:'Loading'"`
+	ErrorCode          string
+	OriginErrorMessage string
+	FileSize           int
+	UserID             uint
+	ImportType         string `gorm:"not null;default:'SYSTEM'"`
+	DecodeTemplateID   uint   `gorm:"not null"`
+	MaterialVersionID  uint   `gorm:"not null;default: 0"`
+	Blocked            bool   `gorm:"default:false"`
+	Yield              float64
+}
+
+func (importRecordV1) TableName() string { return "import_records" }
+
+// productV1 产品表
+type productV1 struct {
+	ID                uint      `gorm:"column:id;primary_key"`
+	ImportRecordID    uint      `gorm:"COMMENT:'导入记录ID';column:import_record_id;not null;index"`
+	MaterialVersionID uint      `gorm:"COMMENT:'料号版本ID';index"`
+	MaterialID        uint      `gorm:"COMMENT:'料号ID';column:material_id;not null;index"`
+	DeviceID          uint      `gorm:"COMMENT:'检测设备ID';column:device_id;not null;index"`
+	Qualified         bool      `gorm:"COMMENT:'产品尺寸是否合格';column:qualified;default:false"`
+	BarCode           string    `gorm:"COMMENT:'识别条码';column:bar_code;"`
+	BarCodeStatus     int       `gorm:"COMMENT:'条码解析状态';column:bar_code_status;default:1"`
+	CreatedAt         time.Time `gorm:"COMMENT:'产品检测时间';index"`
+	Attribute         Map       `gorm:"COMMENT:'产品属性值集合';not null"`
+	PointValues       Map       `gorm:"COMMENT:'产品点位检测值集合';not null"`
+}
+
+func (productV1) TableName() string { return "products" }
+
+// pointSpecV2 点位规格表
+type pointSpecV2 struct {
+	gorm.Model
+	MaterialVersionID uint     `gorm:"COMMENT:'料号版本ID';not null;unique_index:uidx_version_point_name"`
+	Name              string   `gorm:"COMMENT:'点位名称';not null;unique_index:uidx_version_point_name"`
+	Nominal           float64  `gorm:"COMMENT:'标准值'"`
+	USL               *float64 `gorm:"COMMENT:'规格上限';column:usl"`
+	LSL               *float64 `gorm:"COMMENT:'规格下限';column:lsl"`
+	Unit              string   `gorm:"COMMENT:'单位'"`
+	CenterLine        *float64 `gorm:"COMMENT:'控制图中心线'"`
+	UCL               *float64 `gorm:"COMMENT:'控制上限';column:ucl"`
+	LCL               *float64 `gorm:"COMMENT:'控制下限';column:lcl"`
+}
+
+func (pointSpecV2) TableName() string { return "point_specs" }
+
+// spcStatV2 SPC统计累加量表
+type spcStatV2 struct {
+	gorm.Model
+	MaterialVersionID uint      `gorm:"COMMENT:'料号版本ID';not null;unique_index:uidx_spc_stat_window"`
+	DeviceID          uint      `gorm:"COMMENT:'检测设备ID';not null;unique_index:uidx_spc_stat_window"`
+	PointName         string    `gorm:"COMMENT:'点位名称';not null;unique_index:uidx_spc_stat_window"`
+	WindowStart       time.Time `gorm:"COMMENT:'统计窗口开始时间';not null;unique_index:uidx_spc_stat_window"`
+	Count             int       `gorm:"COMMENT:'样本数量'"`
+	Mean              float64   `gorm:"COMMENT:'均值'"`
+	M2                float64   `gorm:"COMMENT:'离差平方和';column:m2"`
+	Min               float64   `gorm:"COMMENT:'最小值'"`
+	Max               float64   `gorm:"COMMENT:'最大值'"`
+	MovingRangeSum    float64   `gorm:"COMMENT:'移动极差之和'"`
+	MovingRangeCount  int       `gorm:"COMMENT:'移动极差数量'"`
+	LastValue         float64   `gorm:"COMMENT:'窗口内最后一个检测值'"`
+	Histogram         Histogram `gorm:"COMMENT:'直方图分布'"`
+}
+
+func (spcStatV2) TableName() string { return "spc_stats" }
+
+// controlViolationV2 控制图违规事件表
+type controlViolationV2 struct {
+	ID                uint      `gorm:"column:id;primary_key"`
+	MaterialVersionID uint      `gorm:"COMMENT:'料号版本ID';not null;index"`
+	DeviceID          uint      `gorm:"COMMENT:'检测设备ID';not null;index"`
+	ProductID         uint      `gorm:"COMMENT:'触发规则的产品ID';not null"`
+	PointName         string    `gorm:"COMMENT:'点位名称';not null"`
+	Rule              int       `gorm:"COMMENT:'判异规则编号';not null"`
+	Description       string    `gorm:"COMMENT:'判异规则描述'"`
+	Value             float64   `gorm:"COMMENT:'检测值'"`
+	CenterLine        float64   `gorm:"COMMENT:'中心线'"`
+	UCL               float64   `gorm:"COMMENT:'控制上限';column:ucl"`
+	LCL               float64   `gorm:"COMMENT:'控制下限';column:lcl"`
+	CreatedAt         time.Time `gorm:"COMMENT:'触发时间';index"`
+}
+
+func (controlViolationV2) TableName() string { return "control_violations" }
+
+// productV4 产品表增加的设备消息ID，与设备ID组成唯一索引
+type productV4 struct {
+	DeviceID  uint    `gorm:"unique_index:uidx_device_message_id"`
+	MessageID *string `gorm:"COMMENT:'设备上传消息ID';column:message_id;unique_index:uidx_device_message_id"`
+}
+
+func (productV4) TableName() string { return "products" }
+
+// productV5 产品表增加的点位判定字段
+// 已有数据的表无法直接增加非空的 JSON 字段，point_judgements 允许为空
+type productV5 struct {
+	PointValuesInvalid bool `gorm:"COMMENT:'点位检测值是否存在缺失或非法值';column:point_values_invalid;default:false"`
+	PointJudgements    Map  `gorm:"COMMENT:'点位判定结果集合'"`
+	QualifiedMismatch  bool `gorm:"COMMENT:'设备判定与规格判定是否不一致';column:qualified_mismatch;default:false"`
+}
+
+func (productV5) TableName() string { return "products" }
+
+// importRecordV6 导入记录表增加的合格数
+type importRecordV6 struct {
+	QualifiedCount int `gorm:"not null;default:0"`
+}
+
+func (importRecordV6) TableName() string { return "import_records" }
+
+// materialVersionV6 料号版本表增加的合格数
+type materialVersionV6 struct {
+	QualifiedCount int `gorm:"not null;default:0"`
+}
+
+func (materialVersionV6) TableName() string { return "material_versions" }
+
+// importRecordV7 导入记录表增加的班次
+type importRecordV7 struct {
+	Shift string
+}
+
+func (importRecordV7) TableName() string { return "import_records" }
@@ -0,0 +1,117 @@
+package orm
+
+import (
+	"testing"
+)
+
+func TestMigrateUpAndDown(t *testing.T) {
+	openTestDB(t, 0)
+
+	statuses, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("statuses = %v, want %v", len(statuses), len(migrations))
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("migration %d_%s is not applied", status.Version, status.Name)
+		}
+	}
+	for _, table := range []string{"products", "import_records", "material_versions", "point_specs", "spc_stats", "control_violations"} {
+		if !DB.HasTable(table) {
+			t.Errorf("table %s does not exist", table)
+		}
+	}
+	if done, err := MigrateUp(0); err != nil || len(done) != 0 {
+		t.Errorf("migrate up again = (%v, %v), want nothing to do", len(done), err)
+	}
+
+	// 最后一个迁移可回滚，SQLite 不支持删除字段，回滚增加字段的迁移时失败并保留版本记录
+	done, err := MigrateDown(2)
+	if err == nil {
+		t.Fatal("migrate down added columns on sqlite should fail")
+	}
+	if len(done) != 1 || done[0].Name != "seed_qualified_counts" {
+		t.Fatalf("migrate down = %v, want seed_qualified_counts only", done)
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := applied[7]; !ok {
+		t.Error("version 7 should still be applied")
+	}
+	if _, ok := applied[8]; ok {
+		t.Error("version 8 should be rolled back")
+	}
+}
+
+func TestMigrationsRepeatable(t *testing.T) {
+	openTestDB(t, 0)
+
+	// MySQL 的 DDL 隐式提交，失败后重新执行的迁移可能已部分生效
+	for _, m := range migrations {
+		if err := m.Up(DB); err != nil {
+			t.Errorf("migration %d_%s up again failed: %v", m.Version, m.Name, err)
+		}
+	}
+	for _, version := range []uint{2, 3} {
+		m := migrations[version-1]
+		for i := 0; i < 2; i++ {
+			if err := m.Down(DB); err != nil {
+				t.Errorf("migration %d_%s down #%d failed: %v", m.Version, m.Name, i+1, err)
+			}
+		}
+	}
+	if DB.Dialect().HasIndex("import_records", realtimeRecordIndex) || DB.HasTable("spc_stats") {
+		t.Error("index and tables should be dropped")
+	}
+}
+
+func TestMigrateDownKeepsSharedTables(t *testing.T) {
+	openTestDB(t, 3)
+
+	if _, err := MigrateDown(3); err == nil {
+		t.Fatal("migrate down version 1 should fail")
+	}
+	if !DB.HasTable("devices") || !DB.HasTable("products") {
+		t.Error("shared tables should not be dropped")
+	}
+	if DB.HasTable("spc_stats") {
+		t.Error("spc tables should be dropped")
+	}
+}
+
+func TestSeedQualifiedCounts(t *testing.T) {
+	openTestDB(t, 7)
+
+	err := DB.Exec(`INSERT INTO material_versions (version, material_id, amount, yield) VALUES ('v1', 1, 7, ?)`, 6.0/7).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DB.Exec(`INSERT INTO import_records (file_name, path, material_id, device_id, decode_template_id, row_finished_count, yield)
+		VALUES ('file', 'path', 1, 1, 0, 4, 0.75)`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+
+	var version MaterialVersion
+	if err := DB.First(&version).Error; err != nil {
+		t.Fatal(err)
+	}
+	if version.QualifiedCount != 6 {
+		t.Errorf("material_version qualified_count = %v, want 6", version.QualifiedCount)
+	}
+	var record ImportRecord
+	if err := DB.First(&record).Error; err != nil {
+		t.Fatal(err)
+	}
+	if record.QualifiedCount != 3 {
+		t.Errorf("import_record qualified_count = %v, want 3", record.QualifiedCount)
+	}
+}
@@ -1,59 +1,205 @@
 package orm
 
 import (
+	"errors"
 	"fmt"
-	"github.com/SasukeBo/configer"
 	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/clock"
 	"github.com/jinzhu/gorm"
+	"net/url"
+	"reflect"
 	"time"
 
 	// set db driver
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// 支持的数据库类型
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite3"
 )
 
 // DB connection to database
 var DB *gorm.DB
 
-func createUriWithDBName(name string) string {
-	return fmt.Sprintf(
-		"%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		configer.GetString("db_user"),
-		configer.GetString("db_pass"),
-		configer.GetString("db_host"),
-		configer.GetString("db_port"),
-		name,
-	)
+// Options 数据库连接配置
+type Options struct {
+	Driver   string // 数据库类型，支持 mysql、postgres、sqlite3，为空时为 mysql
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string // 数据库名称，sqlite3 为数据库文件路径
+
+	MaxOpenConns    int           // 最大连接数，0为不限制
+	MaxIdleConns    int           // 最大空闲连接数，0使用默认值
+	ConnMaxLifetime time.Duration // 连接最长复用时间，0为不限制
+	ConnectTimeout  time.Duration // 建立连接的超时时间，0为不限制
+
+	RetryLimit    int           // 连接失败的重试次数
+	RetryInterval time.Duration // 首次重试的等待时间，之后每次重试递增
+
+	LogMode bool // 是否打印SQL
 }
 
 func init() {
-	var err error
-	var uri = createUriWithDBName("mysql")
-	var dbname = configer.GetString("db_name")
-
-	reconnectLimit := 5
-	for {
-		conn, err := gorm.Open("mysql", uri)
-		if err != nil && reconnectLimit > 0 {
-			log.Errorln(err)
-			reconnectLimit--
-			time.Sleep(time.Duration(5-reconnectLimit) * 2 * time.Second)
-			log.Info("open connection with %s failed, try again ...\n", uri)
-			continue
-		}
-		conn.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", dbname))
-		conn.Close()
-		break
-	}
-
-	DB, err = gorm.Open("mysql", createUriWithDBName(dbname))
+	// 字段注释（COMMENT）仅 MySQL 支持，其他数据库建表时忽略
+	var parseFieldStruct = gorm.ParseFieldStructForDialect
+	gorm.ParseFieldStructForDialect = func(field *gorm.StructField, d gorm.Dialect) (reflect.Value, string, int, string) {
+		if d.GetName() != DialectMySQL {
+			field.TagSettingsDelete("COMMENT")
+		}
+		return parseFieldStruct(field, d)
+	}
+	// 创建时间等使用工厂时区的时钟
+	gorm.NowFunc = clock.Now
+}
+
+// dialect 返回配置的数据库类型
+func (o *Options) dialect() (string, error) {
+	switch o.Driver {
+	case "", DialectMySQL:
+		return DialectMySQL, nil
+	case DialectPostgres, "postgresql":
+		return DialectPostgres, nil
+	case DialectSQLite, "sqlite":
+		return DialectSQLite, nil
+	default:
+		return "", fmt.Errorf("unsupported db driver %q", o.Driver)
+	}
+}
+
+func (o *Options) createUriWithDBName(dialect, name string) string {
+	switch dialect {
+	case DialectPostgres:
+		uri := fmt.Sprintf(
+			"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable TimeZone=%s",
+			o.Host, o.Port, o.User, o.Password, name, clock.Location().String(),
+		)
+		if o.ConnectTimeout > 0 {
+			uri = fmt.Sprintf("%s connect_timeout=%d", uri, int(o.ConnectTimeout.Seconds()))
+		}
+		return uri
+	case DialectSQLite:
+		// SQLite 以 Name 作为数据库文件路径
+		// 事务以 BEGIN IMMEDIATE 开始，并发写入时等待而不是在升级写锁时直接失败
+		return fmt.Sprintf("file:%s?_busy_timeout=5000&_txlock=immediate&_loc=%s", name, url.QueryEscape(clock.Location().String()))
+	}
+
+	uri := fmt.Sprintf(
+		"%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=%s",
+		o.User, o.Password, o.Host, o.Port, name, url.QueryEscape(clock.Location().String()),
+	)
+	if o.ConnectTimeout > 0 {
+		uri = fmt.Sprintf("%s&timeout=%s", uri, o.ConnectTimeout)
+	}
+	return uri
+}
+
+// createDatabase 连接数据库服务的默认库，数据库不存在时创建，SQLite 打开文件时自动创建
+func (o *Options) createDatabase(dialect string) error {
+	var defaultDB string
+	switch dialect {
+	case DialectMySQL:
+		defaultDB = "mysql"
+	case DialectPostgres:
+		defaultDB = "postgres"
+	default:
+		return nil
+	}
+
+	conn, err := gorm.Open(dialect, o.createUriWithDBName(dialect, defaultDB))
 	if err != nil {
-		panic(err)
+		return err
 	}
-	DB.LogMode(false)
-	env := configer.GetString("env")
-	log.Warn("Current runtime environment is %s", env)
+	defer conn.Close()
 
+	if dialect == DialectPostgres {
+		// PostgreSQL 不支持 CREATE DATABASE IF NOT EXISTS
+		var count int
+		if err := conn.Raw("SELECT COUNT(*) FROM pg_database WHERE datname = ?", o.Name).Row().Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return conn.Exec(fmt.Sprintf("CREATE DATABASE %s", o.Name)).Error
+	}
+	return conn.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", o.Name)).Error
+}
+
+// Open 按配置连接数据库，数据库不存在时创建，连接失败时按重试策略重试
+// 连接成功后设置为全局的 DB
+func Open(options Options) (*gorm.DB, error) {
+	dialect, err := options.dialect()
 	if err != nil {
-		panic(fmt.Errorf("migrate to db error: \n%v", err.Error()))
+		return nil, err
+	}
+
+	var conn *gorm.DB
+	for retry := 0; ; retry++ {
+		if err = options.createDatabase(dialect); err == nil {
+			conn, err = gorm.Open(dialect, options.createUriWithDBName(dialect, options.Name))
+		}
+		if err == nil {
+			break
+		}
+		if retry >= options.RetryLimit {
+			return nil, fmt.Errorf("open %s database %s failed: %v", dialect, options.Name, err)
+		}
+		log.Errorln(err)
+		log.Info("open %s database %s failed, try again ...", dialect, options.Name)
+		time.Sleep(time.Duration(retry+1) * options.RetryInterval)
+	}
+
+	if dialect == DialectSQLite {
+		// SQLite 不支持并发写入，使用单个连接串行访问
+		conn.DB().SetMaxOpenConns(1)
+	} else {
+		conn.DB().SetMaxOpenConns(options.MaxOpenConns)
+	}
+	if options.MaxIdleConns > 0 {
+		conn.DB().SetMaxIdleConns(options.MaxIdleConns)
+	}
+	conn.DB().SetConnMaxLifetime(options.ConnMaxLifetime)
+	conn.LogMode(options.LogMode)
+
+	consecutiveAutoIncrement = true
+	if dialect == DialectMySQL {
+		// 无法确认自增ID连续时按不连续处理
+		var mode int
+		if err := conn.Raw("SELECT @@innodb_autoinc_lock_mode").Row().Scan(&mode); err != nil {
+			log.Warn("get innodb_autoinc_lock_mode failed, insert products one row per statement: %v", err)
+			consecutiveAutoIncrement = false
+		} else if mode == 2 {
+			log.Warn("innodb_autoinc_lock_mode is 2, insert products one row per statement")
+			consecutiveAutoIncrement = false
+		}
+	}
+
+	DB = conn
+	return conn, nil
+}
+
+// Close 关闭全局的数据库连接
+func Close() error {
+	if DB == nil {
+		return errors.New("database is not opened")
+	}
+	err := DB.Close()
+	DB = nil
+	return err
+}
+
+// forUpdate 查询时锁定读取的行直到事务结束
+// SQLite 不支持 FOR UPDATE，其事务以 BEGIN IMMEDIATE 开始，已互斥执行
+func forUpdate(tx *gorm.DB) *gorm.DB {
+	if tx.Dialect().GetName() == DialectSQLite {
+		return tx
 	}
+	return tx.Set("gorm:query_option", "FOR UPDATE")
 }
@@ -0,0 +1,56 @@
+package orm
+
+import (
+	"fmt"
+	"github.com/SasukeBo/pmes-data-producer/cache"
+	"github.com/jinzhu/gorm"
+)
+
+// PointSpec 料号版本的检测点位规格
+// USL、LSL 为空时表示该侧不设限
+// CenterLine、UCL、LCL 为单值控制图的控制限
+type PointSpec struct {
+	gorm.Model
+	MaterialVersionID uint     `gorm:"COMMENT:'料号版本ID';not null;unique_index:uidx_version_point_name"`
+	Name              string   `gorm:"COMMENT:'点位名称';not null;unique_index:uidx_version_point_name"`
+	Nominal           float64  `gorm:"COMMENT:'标准值'"`
+	USL               *float64 `gorm:"COMMENT:'规格上限';column:usl"`
+	LSL               *float64 `gorm:"COMMENT:'规格下限';column:lsl"`
+	Unit              string   `gorm:"COMMENT:'单位'"`
+	CenterLine        *float64 `gorm:"COMMENT:'控制图中心线'"` // 控制限为空时由历史统计量推算
+	UCL               *float64 `gorm:"COMMENT:'控制上限';column:ucl"`
+	LCL               *float64 `gorm:"COMMENT:'控制下限';column:lcl"`
+}
+
+const (
+	PointJudgementOK = "OK"
+	PointJudgementNG = "NG"
+)
+
+const pointSpecsCacheKey = "cache_point_specs_version_%v"
+
+// Judge 判断检测值是否在规格范围内
+func (ps *PointSpec) Judge(value float64) bool {
+	if ps.USL != nil && value > *ps.USL {
+		return false
+	}
+	if ps.LSL != nil && value < *ps.LSL {
+		return false
+	}
+	return true
+}
+
+// GetPointSpecsWithVersionID 获取料号版本的全部点位规格
+func GetPointSpecsWithVersionID(versionID uint) ([]PointSpec, error) {
+	cacheKey := fmt.Sprintf(pointSpecsCacheKey, versionID)
+	if specs, ok := cache.Get(cacheKey).([]PointSpec); ok {
+		return specs, nil
+	}
+
+	var specs []PointSpec
+	if err := DB.Model(&PointSpec{}).Where("material_version_id = ?", versionID).Find(&specs).Error; err != nil {
+		return nil, fmt.Errorf("get point_specs with material_version_id = %v failed: %v", versionID, err)
+	}
+	_ = cache.Set(cacheKey, specs)
+	return specs, nil
+}
@@ -1,18 +1,143 @@
 package orm
 
-import "time"
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
 
 // Product 产品表
 type Product struct {
-	ID                uint      `gorm:"column:id;primary_key"`
-	ImportRecordID    uint      `gorm:"COMMENT:'导入记录ID';column:import_record_id;not null;index"`
-	MaterialVersionID uint      `gorm:"COMMENT:'料号版本ID';index"`
-	MaterialID        uint      `gorm:"COMMENT:'料号ID';column:material_id;not null;index"`
-	DeviceID          uint      `gorm:"COMMENT:'检测设备ID';column:device_id;not null;index"`
-	Qualified         bool      `gorm:"COMMENT:'产品尺寸是否合格';column:qualified;default:false"`
-	BarCode           string    `gorm:"COMMENT:'识别条码';column:bar_code;"`
-	BarCodeStatus     int       `gorm:"COMMENT:'条码解析状态';column:bar_code_status;default:1"`
-	CreatedAt         time.Time `gorm:"COMMENT:'产品检测时间';index"` // 检测时间
-	Attribute         Map       `gorm:"COMMENT:'产品属性值集合';type:JSON;not null"`
-	PointValues       Map       `gorm:"COMMENT:'产品点位检测值集合';type:JSON;not null"`
+	ID                 uint      `gorm:"column:id;primary_key"`
+	ImportRecordID     uint      `gorm:"COMMENT:'导入记录ID';column:import_record_id;not null;index"`
+	MaterialVersionID  uint      `gorm:"COMMENT:'料号版本ID';index"`
+	MaterialID         uint      `gorm:"COMMENT:'料号ID';column:material_id;not null;index"`
+	DeviceID           uint      `gorm:"COMMENT:'检测设备ID';column:device_id;not null;index;unique_index:uidx_device_message_id"`
+	Qualified          bool      `gorm:"COMMENT:'产品尺寸是否合格';column:qualified;default:false"` // 存在点位规格时为服务端判定结果
+	BarCode            string    `gorm:"COMMENT:'识别条码';column:bar_code;"`
+	BarCodeStatus      int       `gorm:"COMMENT:'条码解析状态';column:bar_code_status;default:1"`
+	CreatedAt          time.Time `gorm:"COMMENT:'产品检测时间';index"` // 检测时间
+	Attribute          Map       `gorm:"COMMENT:'产品属性值集合';not null"`
+	PointValues        Map       `gorm:"COMMENT:'产品点位检测值集合';not null"`
+	PointValuesInvalid bool      `gorm:"COMMENT:'点位检测值是否存在缺失或非法值';column:point_values_invalid;default:false"`
+	PointJudgements    Map       `gorm:"COMMENT:'点位判定结果集合';not null"`                                              // 按点位规格判定的OK/NG
+	QualifiedMismatch  bool      `gorm:"COMMENT:'设备判定与规格判定是否不一致';column:qualified_mismatch;default:false"`         // 设备上传的合格判定与服务端判定不一致
+	MessageID          *string   `gorm:"COMMENT:'设备上传消息ID';column:message_id;unique_index:uidx_device_message_id"` // 设备生成的消息ID，用于重传去重
+}
+
+// GetWithMessageID 获取设备以指定消息ID上传的产品
+func (p *Product) GetWithMessageID(deviceID uint, messageID string) error {
+	if err := DB.Model(p).Where("device_id = ? AND message_id = ?", deviceID, messageID).First(p).Error; err != nil {
+		return fmt.Errorf("get product with device_id = %v and message_id = %v failed: %v", deviceID, messageID, err)
+	}
+
+	return nil
+}
+
+// productColumns 批量写入产品时的字段
+var productColumns = []string{
+	"import_record_id", "material_version_id", "material_id", "device_id", "qualified", "bar_code", "bar_code_status",
+	"created_at", "attribute", "point_values", "point_values_invalid", "point_judgements", "qualified_mismatch", "message_id",
+}
+
+// productBatchSize 单条 INSERT 语句写入的最大产品数量，避免超出数据库的参数数量限制
+const productBatchSize = 500
+
+// sqliteMaxVariables SQLite 单条语句的参数数量上限
+const sqliteMaxVariables = 999
+
+// consecutiveAutoIncrement 同一条多行 INSERT 语句分配的自增ID是否连续，由 Open 检测
+// MySQL innodb_autoinc_lock_mode 为 2（MySQL 8 的默认值）时并发插入的自增ID可能交错
+var consecutiveAutoIncrement = true
+
+// CreateProducts 使用多行 INSERT 批量写入产品，db 可以为事务，写入后回填产品ID
+// PostgreSQL 通过 RETURNING 获取ID；MySQL 及 SQLite 由 LastInsertId 推算，
+// MySQL 自增ID不保证连续时每条语句只写入一个产品，以 LastInsertId 作为产品ID
+func CreateProducts(db *gorm.DB, products []*Product) error {
+	for _, batch := range productBatches(products, productBatchSizeOf(db)) {
+		if err := createProducts(db, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productBatchSizeOf 数据库单条 INSERT 语句写入的最大产品数量，SQLite 受参数数量上限限制
+func productBatchSizeOf(db *gorm.DB) int {
+	switch db.Dialect().GetName() {
+	case DialectSQLite:
+		return sqliteMaxVariables / len(productColumns)
+	case DialectMySQL:
+		if !consecutiveAutoIncrement {
+			return 1
+		}
+	}
+	return productBatchSize
+}
+
+// productBatches 按单条语句的最大产品数量拆分产品
+func productBatches(products []*Product, size int) [][]*Product {
+	var batches [][]*Product
+	for start := 0; start < len(products); start += size {
+		end := start + size
+		if end > len(products) {
+			end = len(products)
+		}
+		batches = append(batches, products[start:end])
+	}
+	return batches
+}
+
+func createProducts(db *gorm.DB, products []*Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(productColumns)), ", ") + ")"
+	var rows []string
+	var args []interface{}
+	for _, p := range products {
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = gorm.NowFunc()
+		}
+		rows = append(rows, placeholder)
+		args = append(args,
+			p.ImportRecordID, p.MaterialVersionID, p.MaterialID, p.DeviceID, p.Qualified, p.BarCode, p.BarCodeStatus,
+			p.CreatedAt, p.Attribute, p.PointValues, p.PointValuesInvalid, p.PointJudgements, p.QualifiedMismatch, p.MessageID,
+		)
+	}
+	query := fmt.Sprintf("INSERT INTO products (%s) VALUES %s", strings.Join(productColumns, ", "), strings.Join(rows, ", "))
+
+	if db.Dialect().GetName() == DialectPostgres {
+		result, err := db.Raw(query+" RETURNING id", args...).Rows()
+		if err != nil {
+			return fmt.Errorf("create products failed: %v", err)
+		}
+		defer result.Close()
+		for idx := 0; result.Next() && idx < len(products); idx++ {
+			if err := result.Scan(&products[idx].ID); err != nil {
+				return fmt.Errorf("scan id of products failed: %v", err)
+			}
+		}
+		return result.Err()
+	}
+
+	result, err := db.CommonDB().Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("create products failed: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get id of products failed: %v", err)
+	}
+	// MySQL 返回第一行的ID，SQLite 返回最后一行的ID
+	first := uint(id)
+	if db.Dialect().GetName() == DialectSQLite {
+		first = uint(id) - uint(len(products)) + 1
+	}
+	for idx, p := range products {
+		p.ID = first + uint(idx)
+	}
+	return nil
 }
@@ -0,0 +1,57 @@
+package orm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCreateProducts(t *testing.T) {
+	openTestDB(t, 0)
+
+	// SQLite 单条语句最多 999 个参数，每条语句最多写入 999/14 = 71 个产品
+	size := productBatchSizeOf(DB)
+	if size != 71 || size*len(productColumns) > sqliteMaxVariables {
+		t.Fatalf("sqlite batch size = %v, want 71", size)
+	}
+
+	var products []*Product
+	for idx := 0; idx < size+3; idx++ {
+		messageID := fmt.Sprintf("message-%v", idx)
+		products = append(products, &Product{
+			ImportRecordID: 1,
+			MaterialID:     1,
+			DeviceID:       1,
+			BarCode:        fmt.Sprintf("code-%v", idx),
+			Attribute:      Map{"line": "A"},
+			PointValues:    Map{"p1": 1.5},
+			MessageID:      &messageID,
+		})
+	}
+	batches := productBatches(products, size)
+	if len(batches) != 2 || len(batches[0]) != size || len(batches[1]) != 3 {
+		t.Fatalf("batches = %v, want %v and 3 products", len(batches), size)
+	}
+	if err := CreateProducts(DB, products); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, idx := range []int{0, size - 1, size, len(products) - 1} {
+		var product Product
+		if err := product.GetWithMessageID(1, *products[idx].MessageID); err != nil {
+			t.Fatal(err)
+		}
+		if product.ID != products[idx].ID || product.BarCode != products[idx].BarCode {
+			t.Errorf("product %v = (%v, %v), want (%v, %v)", idx, product.ID, product.BarCode, products[idx].ID, products[idx].BarCode)
+		}
+		if product.PointValues["p1"] != 1.5 || product.Attribute["line"] != "A" {
+			t.Errorf("product %v values = (%v, %v)", idx, product.PointValues, product.Attribute)
+		}
+	}
+
+	// 同一设备的消息ID唯一
+	duplicate := *products[0]
+	duplicate.ID = 0
+	if err := CreateProducts(DB, []*Product{&duplicate}); err == nil {
+		t.Error("create product with duplicate message_id should fail")
+	}
+}
@@ -0,0 +1,111 @@
+package orm
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Shift 班次配置，Start、End 格式为 HH:MM，End 不晚于 Start 时表示跨越零点
+type Shift struct {
+	Name  string `yaml:"name"`
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// ShiftPeriod 某一天的某个班次的时间段 [Start, End)
+type ShiftPeriod struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+type shiftSpan struct {
+	name     string
+	start    time.Duration // 距零点的偏移
+	duration time.Duration
+}
+
+// ShiftCalendar 班次日历，各班次首尾相接覆盖全天
+type ShiftCalendar struct {
+	spans []shiftSpan
+}
+
+// shiftCalendar 实时导入记录按班次分段，未配置班次时整天为一个班次
+var shiftCalendar = &ShiftCalendar{spans: []shiftSpan{{duration: 24 * time.Hour}}}
+
+// SetShiftCalendar 设置实时导入记录使用的班次日历，应在开始接收数据前调用
+func SetShiftCalendar(calendar *ShiftCalendar) {
+	shiftCalendar = calendar
+}
+
+// CurrentShiftPeriod 获取时间所在的班次时间段
+func CurrentShiftPeriod(t time.Time) ShiftPeriod {
+	return shiftCalendar.PeriodAt(t)
+}
+
+// NewShiftCalendar 校验班次配置，班次之间不能重叠或间断，shifts 为空时整天为一个班次
+func NewShiftCalendar(shifts []Shift) (*ShiftCalendar, error) {
+	if len(shifts) == 0 {
+		return &ShiftCalendar{spans: []shiftSpan{{duration: 24 * time.Hour}}}, nil
+	}
+
+	var spans []shiftSpan
+	var total time.Duration
+	for _, shift := range shifts {
+		start, err := parseClock(shift.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start of shift %s: %v", shift.Name, err)
+		}
+		end, err := parseClock(shift.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end of shift %s: %v", shift.Name, err)
+		}
+		duration := end - start
+		if duration <= 0 {
+			duration += 24 * time.Hour
+		}
+		total += duration
+		spans = append(spans, shiftSpan{name: shift.Name, start: start, duration: duration})
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i, span := range spans {
+		next := spans[(i+1)%len(spans)]
+		if (span.start+span.duration)%(24*time.Hour) != next.start {
+			return nil, fmt.Errorf("shift %s should end at the start of shift %s", span.name, next.name)
+		}
+	}
+	if total != 24*time.Hour {
+		return nil, fmt.Errorf("shifts should cover 24 hours, got %v", total)
+	}
+
+	return &ShiftCalendar{spans: spans}, nil
+}
+
+// PeriodAt 获取时间所在的班次时间段，跨越零点的班次属于开始的那一天
+func (c *ShiftCalendar) PeriodAt(t time.Time) ShiftPeriod {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, offset := range []int{0, -1} {
+		base := day.AddDate(0, 0, offset)
+		for _, span := range c.spans {
+			start := base.Add(span.start)
+			end := start.Add(span.duration)
+			if !t.Before(start) && t.Before(end) {
+				return ShiftPeriod{Name: span.name, Start: start, End: end}
+			}
+		}
+	}
+
+	// 班次覆盖全天，不会执行到这里
+	return ShiftPeriod{Start: day, End: day.AddDate(0, 0, 1)}
+}
+
+// parseClock 解析 HH:MM 格式的时间，返回距零点的偏移
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,92 @@
+package orm
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// SPCStat 点位检测值的统计累加量
+// 按料号版本、设备、点位及时间窗口分组，产品写入时增量累加，查询时合并多个窗口
+type SPCStat struct {
+	gorm.Model
+	MaterialVersionID uint      `gorm:"COMMENT:'料号版本ID';not null;unique_index:uidx_spc_stat_window"`
+	DeviceID          uint      `gorm:"COMMENT:'检测设备ID';not null;unique_index:uidx_spc_stat_window"`
+	PointName         string    `gorm:"COMMENT:'点位名称';not null;unique_index:uidx_spc_stat_window"`
+	WindowStart       time.Time `gorm:"COMMENT:'统计窗口开始时间';not null;unique_index:uidx_spc_stat_window"`
+	Count             int       `gorm:"COMMENT:'样本数量'"`
+	Mean              float64   `gorm:"COMMENT:'均值'"`
+	M2                float64   `gorm:"COMMENT:'离差平方和';column:m2"`
+	Min               float64   `gorm:"COMMENT:'最小值'"`
+	Max               float64   `gorm:"COMMENT:'最大值'"`
+	MovingRangeSum    float64   `gorm:"COMMENT:'移动极差之和'"`
+	MovingRangeCount  int       `gorm:"COMMENT:'移动极差数量'"`
+	LastValue         float64   `gorm:"COMMENT:'窗口内最后一个检测值'"`
+	Histogram         Histogram `gorm:"COMMENT:'直方图分布'"`
+}
+
+// AccumulateSPCStat 将统计窗口的增量合并到数据库中的累加量
+// 在事务中锁定窗口后由 merge 合并，窗口不存在时以增量创建，
+// 多个实例写入同一窗口时依次合并，不会相互覆盖
+func AccumulateSPCStat(delta *SPCStat, merge func(stat, delta *SPCStat)) error {
+	var err error
+	for retry := 0; retry < 2; retry++ {
+		var created bool
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			var stat SPCStat
+			query := "material_version_id = ? AND device_id = ? AND point_name = ? AND window_start = ?"
+			err := forUpdate(tx).Where(query, delta.MaterialVersionID, delta.DeviceID, delta.PointName, delta.WindowStart).First(&stat).Error
+			if gorm.IsRecordNotFoundError(err) {
+				created = true
+				stat = *delta
+				stat.Model = gorm.Model{}
+				return tx.Create(&stat).Error
+			}
+			if err != nil {
+				return err
+			}
+			merge(&stat, delta)
+			return tx.Save(&stat).Error
+		})
+		// 其他实例同时创建了同一窗口时违反唯一索引，重试一次合并到已创建的窗口
+		if err == nil || !created {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("accumulate spc_stat of version %v device %v point %s at %v failed: %v", delta.MaterialVersionID, delta.DeviceID, delta.PointName, delta.WindowStart, err)
+	}
+	return nil
+}
+
+// SPCStatQuery 统计累加量查询条件，DeviceID、PointName 为零值时不做过滤
+type SPCStatQuery struct {
+	MaterialVersionID uint
+	DeviceID          uint
+	PointName         string
+	Begin             time.Time
+	End               time.Time
+}
+
+// FindSPCStats 查询时间范围内的统计累加量
+func FindSPCStats(q SPCStatQuery) ([]SPCStat, error) {
+	query := DB.Model(&SPCStat{}).Where("material_version_id = ?", q.MaterialVersionID)
+	if q.DeviceID != 0 {
+		query = query.Where("device_id = ?", q.DeviceID)
+	}
+	if q.PointName != "" {
+		query = query.Where("point_name = ?", q.PointName)
+	}
+	if !q.Begin.IsZero() {
+		query = query.Where("window_start >= ?", q.Begin)
+	}
+	if !q.End.IsZero() {
+		query = query.Where("window_start < ?", q.End)
+	}
+
+	var stats []SPCStat
+	if err := query.Order("window_start").Find(&stats).Error; err != nil {
+		return nil, fmt.Errorf("find spc_stats of version %v failed: %v", q.MaterialVersionID, err)
+	}
+	return stats, nil
+}
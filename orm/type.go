@@ -4,10 +4,27 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"github.com/jinzhu/gorm"
 )
 
+// jsonDataType 返回各数据库存储JSON的字段类型
+func jsonDataType(dialect gorm.Dialect) string {
+	switch dialect.GetName() {
+	case DialectPostgres:
+		return "JSONB"
+	case DialectSQLite:
+		return "TEXT"
+	default:
+		return "JSON"
+	}
+}
+
 type Map map[string]interface{}
 
+func (Map) GormDataType(dialect gorm.Dialect) string {
+	return jsonDataType(dialect)
+}
+
 func (m Map) Value() (driver.Value, error) {
 	bytes, err := json.Marshal(m)
 	return string(bytes), err
@@ -15,6 +32,10 @@ func (m Map) Value() (driver.Value, error) {
 
 func (m *Map) Scan(input interface{}) error {
 	switch value := input.(type) {
+	case nil:
+		// 增加字段前已存在的数据为空
+		*m = nil
+		return nil
 	case string:
 		return json.Unmarshal([]byte(value), m)
 	case []byte:
@@ -23,3 +44,34 @@ func (m *Map) Scan(input interface{}) error {
 		return errors.New("cannot unmarshal value into types.Map")
 	}
 }
+
+// Histogram 直方图分布
+// [Lower, Upper] 等分为 len(Counts)-2 个区间，Counts 首尾分别为低于下限及高于上限的数量
+type Histogram struct {
+	Lower  float64 `json:"lower"`
+	Upper  float64 `json:"upper"`
+	Counts []int   `json:"counts"`
+}
+
+func (Histogram) GormDataType(dialect gorm.Dialect) string {
+	return jsonDataType(dialect)
+}
+
+func (h Histogram) Value() (driver.Value, error) {
+	bytes, err := json.Marshal(h)
+	return string(bytes), err
+}
+
+func (h *Histogram) Scan(input interface{}) error {
+	switch value := input.(type) {
+	case string:
+		return json.Unmarshal([]byte(value), h)
+	case []byte:
+		return json.Unmarshal(value, h)
+	case nil:
+		*h = Histogram{}
+		return nil
+	default:
+		return errors.New("cannot unmarshal value into types.Histogram")
+	}
+}
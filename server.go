@@ -1,23 +1,268 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/SasukeBo/configer"
 	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/alert"
+	"github.com/SasukeBo/pmes-data-producer/cache"
+	"github.com/SasukeBo/pmes-data-producer/clock"
 	"github.com/SasukeBo/pmes-data-producer/handler"
+	"github.com/SasukeBo/pmes-data-producer/listener"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"github.com/SasukeBo/pmes-data-producer/service"
+	"github.com/SasukeBo/pmes-data-producer/spc"
 	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// 工厂时区需在连接数据库之前设置
+	if err := clock.SetTimezone(configer.GetString("timezone")); err != nil {
+		panic(err)
+	}
+	if err := cache.Init(cache.Options{
+		ExpiredTime: time.Duration(configer.GetInt("cache_expired_time")) * time.Second,
+	}); err != nil {
+		panic(err)
+	}
+	if _, err := orm.Open(orm.Options{
+		Driver:          configer.GetString("db_driver"),
+		Host:            configer.GetString("db_host"),
+		Port:            configer.GetString("db_port"),
+		User:            configer.GetString("db_user"),
+		Password:        configer.GetString("db_pass"),
+		Name:            configer.GetString("db_name"),
+		MaxOpenConns:    configer.GetInt("db_max_open_conns"),
+		MaxIdleConns:    configer.GetInt("db_max_idle_conns"),
+		ConnMaxLifetime: time.Duration(configer.GetInt("db_conn_max_lifetime")) * time.Second,
+		ConnectTimeout:  time.Duration(configer.GetInt("db_connect_timeout")) * time.Second,
+		RetryLimit:      configer.GetInt("db_retry_limit"),
+		RetryInterval:   time.Duration(configer.GetInt("db_retry_interval")) * time.Second,
+	}); err != nil {
+		panic(err)
+	}
+	defer orm.Close()
+	log.Warn("Current runtime environment is %s", configer.GetString("env"))
+
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill": // 由产品表重新计算导入记录及料号版本的完成数及合格数
+			if err := orm.BackfillQualifiedCounts(); err != nil {
+				log.Errorln(err)
+				orm.Close()
+				os.Exit(1)
+			}
+			log.Info("backfill qualified counts finished")
+		case "migrate": // 数据库版本迁移，migrate [up [version] | down [steps] | status]
+			if err := migrate(os.Args[2:]); err != nil {
+				log.Errorln(err)
+				orm.Close()
+				os.Exit(1)
+			}
+		default:
+			log.Error("unknown command %s", os.Args[1])
+			orm.Close()
+			os.Exit(2)
+		}
+		return
+	}
+
 	r := gin.Default()
 	//r.Use(cors.Default())
 
 	// Panic Recovery
 	r.Use(gin.Recovery())
 
+	productService := service.NewProductService(service.ProductServiceOptions{
+		MaxMeasuredDelay: time.Duration(configer.GetInt("measured_at_max_delay")) * time.Second,
+		MaxMeasuredAhead: time.Duration(configer.GetInt("measured_at_max_ahead")) * time.Second,
+		Buffer: service.BufferOptions{
+			Enabled:       configer.GetBool("product_buffer_enabled"),
+			FlushInterval: time.Duration(configer.GetInt("product_buffer_flush_interval")) * time.Millisecond,
+			FlushSize:     configer.GetInt("product_buffer_flush_size"),
+			QueueSize:     configer.GetInt("product_buffer_queue_size"),
+		},
+	})
+
+	// SPC 统计
+	spcEngine := spc.NewEngine(configer.GetInt("spc_histogram_buckets"))
+	spcEngine.Start(time.Duration(configer.GetInt("spc_flush_interval")) * time.Second)
+	defer spcEngine.Stop()
+	controlChart := spc.NewControlChart(spcEngine, configer.GetInt("control_chart_min_samples"))
+	defer controlChart.Stop()
+	productService.Use(spcEngine, controlChart)
+
+	// 告警
+	var alertOptions = alert.Options{
+		Cooldown: time.Duration(configer.GetInt("alert_cooldown")) * time.Second,
+		Retry:    configer.GetInt("alert_retry"),
+		Timeout:  time.Duration(configer.GetInt("alert_timeout")) * time.Second,
+	}
+	if err := decodeConfig("alert_webhooks", &alertOptions.Webhooks); err != nil {
+		panic(err)
+	}
+	if err := decodeConfig("alert_rules", &alertOptions.Rules); err != nil {
+		panic(err)
+	}
+	if len(alertOptions.Rules) > 0 {
+		alerter, err := alert.NewAlerter(alertOptions)
+		if err != nil {
+			panic(err)
+		}
+		alerter.Start()
+		defer alerter.Stop(time.Duration(configer.GetInt("shutdown_timeout")) * time.Second)
+		controlChart.Subscribe(alerter.OnViolation)
+		productService.Use(alerter)
+	}
+
+	// 缓冲写入在接入停止后、告警及SPC统计停止前写完队列中的产品
+	productService.Start()
+	defer productService.Stop()
+
+	// 班次日历
+	var shifts []orm.Shift
+	if err := decodeConfig("shifts", &shifts); err != nil {
+		panic(err)
+	}
+	calendar, err := orm.NewShiftCalendar(shifts)
+	if err != nil {
+		panic(err)
+	}
+	orm.SetShiftCalendar(calendar)
+
+	// 实时导入记录日切
+	rollover, err := service.NewRolloverScheduler(configer.GetString("rollover_cutover"))
+	if err != nil {
+		panic(err)
+	}
+	rollover.Start()
+	defer rollover.Stop()
+
 	// Data transfer
-	r.POST("/produce", handler.HttpRequestLogger(), handler.DeviceProduce()) // 设备上传生产数据
+	r.POST("/produce", handler.HttpRequestLogger(), handler.DeviceProduce(productService))            // 设备上传生产数据
+	r.POST("/produce/batch", handler.HttpRequestLogger(), handler.DeviceProduceBatch(productService)) // 设备批量上传生产数据
+
+	// Query
+	r.GET("/spc", handler.SPCStatistics(spcEngine))   // 查询SPC统计结果
+	r.GET("/violations", handler.ControlViolations()) // 查询控制图违规事件
+
+	// MQTT 接入
+	if broker := configer.GetString("mqtt_broker"); broker != "" {
+		mqttListener, err := listener.NewMQTTListener(productService, listener.MQTTOptions{
+			Broker:   broker,
+			ClientID: configer.GetString("mqtt_client_id"),
+			Username: configer.GetString("mqtt_username"),
+			Password: configer.GetString("mqtt_password"),
+			Topic:    configer.GetString("mqtt_topic"),
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := mqttListener.Start(); err != nil {
+			panic(fmt.Errorf("start mqtt listener failed: %v", err))
+		}
+		defer mqttListener.Stop()
+	}
+
+	// TCP 行协议接入
+	if address := configer.GetString("tcp_address"); address != "" {
+		tcpListener, err := listener.NewTCPListener(productService, listener.TCPOptions{
+			Address:   address,
+			Delimiter: configer.GetString("tcp_delimiter"),
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := tcpListener.Start(); err != nil {
+			panic(fmt.Errorf("start tcp listener failed: %v", err))
+		}
+		defer tcpListener.Stop()
+	}
 
 	log.Info("start service on [%s] mode", configer.GetEnv("env"))
-	r.Run(fmt.Sprintf(":%s", configer.GetString("port")))
+	server := &http.Server{Addr: fmt.Sprintf(":%s", configer.GetString("port")), Handler: r}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("http server stopped: %v", err)
+		}
+	}()
+
+	// 收到退出信号后停止接收请求，等待处理中的请求完成，
+	// 之后按注册的相反顺序停止接入、日切、告警、SPC统计，最后关闭数据库连接
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	log.Info("receive signal %v, shutting down ...", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(configer.GetInt("shutdown_timeout"))*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("shutdown http server failed: %v", err)
+	}
+}
+
+// migrate 执行数据库版本迁移子命令
+func migrate(args []string) error {
+	var action = "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	var number uint64
+	if len(args) > 1 {
+		var err error
+		if number, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			return fmt.Errorf("invalid migrate argument %s: %v", args[1], err)
+		}
+	}
+
+	switch action {
+	case "up":
+		done, err := orm.MigrateUp(uint(number))
+		for _, m := range done {
+			log.Info("migrated up %d_%s", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		if number == 0 {
+			number = 1
+		}
+		done, err := orm.MigrateDown(int(number))
+		for _, m := range done {
+			log.Info("migrated down %d_%s", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := orm.Migrations()
+		for _, s := range statuses {
+			if s.Applied {
+				log.Info("%d_%s applied at %s", s.Version, s.Name, s.AppliedAt.Format(time.RFC3339))
+			} else {
+				log.Info("%d_%s pending", s.Version, s.Name)
+			}
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown migrate action %s", action)
+	}
+}
+
+// decodeConfig 将列表、对象等结构化配置解析到out中
+func decodeConfig(key string, out interface{}) error {
+	content, err := yaml.Marshal(configer.GetEnv(key))
+	if err != nil {
+		return fmt.Errorf("marshal config %s failed: %v", key, err)
+	}
+	if err := yaml.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("decode config %s failed: %v", key, err)
+	}
+	return nil
 }
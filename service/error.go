@@ -0,0 +1,50 @@
+package service
+
+import (
+	"fmt"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+type ErrorCode int
+
+const (
+	ErrorCodeDeviceNotFound ErrorCode = 1 + iota // 设备不存在
+	ErrorCodeRealtimeRecord                      // 获取设备实时导入记录失败
+	ErrorCodeSaveProduct                         // 保存产品失败
+	ErrorCodeInvalidInput                        // 上传数据不合法
+	ErrorCodeBusy                                // 缓冲队列已满
+)
+
+// Error 生产数据处理失败的错误
+// 各接入方式根据 Code 转换为对应的响应，例如HTTP状态码
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Origin  error
+
+	temporary bool // 数据库连接不可用等临时原因导致的失败
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Origin)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Origin
+}
+
+// Temporary 是否为服务端的临时错误，重试可能成功
+// 只有服务繁忙及数据库连接不可用时为临时错误，数据不合法、料号没有当前版本、违反约束等错误重试也不会成功
+func (e *Error) Temporary() bool {
+	return e.Code == ErrorCodeBusy || e.temporary
+}
+
+// newStorageError 读写数据库失败的错误，数据库连接不可用时为临时错误
+func newStorageError(code ErrorCode, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Origin: err, temporary: databaseUnavailable()}
+}
+
+// databaseUnavailable 数据库连接是否不可用，用于区分连接故障与数据本身导致的失败
+func databaseUnavailable() bool {
+	return orm.DB.DB().Ping() != nil
+}
@@ -0,0 +1,20 @@
+package service
+
+import "github.com/SasukeBo/pmes-data-producer/orm"
+
+// ProductHook 产品写入后的回调，用于统计、告警等
+// 重传的重复数据不会触发回调
+type ProductHook interface {
+	AfterProduce(product *orm.Product, specs []orm.PointSpec)
+}
+
+// Use 注册产品写入后的回调，应在开始接收数据前调用
+func (s *ProductService) Use(hooks ...ProductHook) {
+	s.hooks = append(s.hooks, hooks...)
+}
+
+func (s *ProductService) afterProduce(product *orm.Product, specs []orm.PointSpec) {
+	for _, hook := range s.hooks {
+		hook.AfterProduce(product, specs)
+	}
+}
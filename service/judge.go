@@ -0,0 +1,29 @@
+package service
+
+import "github.com/SasukeBo/pmes-data-producer/orm"
+
+// judgeProduct 按料号版本的点位规格判定产品是否合格
+// 无点位规格时保留设备上传的判定结果；有规格时以服务端判定为准，
+// 缺失或非法的点位视为NG，并标记与设备判定不一致的产品
+func judgeProduct(product *orm.Product, specs []orm.PointSpec) {
+	product.PointJudgements = make(orm.Map)
+	if len(specs) == 0 {
+		return
+	}
+
+	var qualified = true
+	for i := range specs {
+		spec := &specs[i]
+		var judgement = orm.PointJudgementNG
+		if value, ok := product.PointValues[spec.Name].(float64); ok && spec.Judge(value) {
+			judgement = orm.PointJudgementOK
+		}
+		if judgement == orm.PointJudgementNG {
+			qualified = false
+		}
+		product.PointJudgements[spec.Name] = judgement
+	}
+
+	product.QualifiedMismatch = product.Qualified != qualified
+	product.Qualified = qualified
+}
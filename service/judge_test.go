@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+func float(v float64) *float64 { return &v }
+
+func TestJudgeProduct(t *testing.T) {
+	specs := []orm.PointSpec{
+		{Name: "p1", USL: float(2), LSL: float(1)},
+		{Name: "p2", USL: float(2)},
+	}
+	cases := []struct {
+		name        string
+		qualified   bool
+		pointValues orm.Map
+		specs       []orm.PointSpec
+		want        bool
+		mismatch    bool
+		judgements  orm.Map
+	}{
+		{"no specs keeps device judgement", false, orm.Map{"p1": 5.0}, nil, false, false, orm.Map{}},
+		{"all in spec", true, orm.Map{"p1": 1.5, "p2": -3.0}, specs, true, false, orm.Map{"p1": "OK", "p2": "OK"}},
+		{"out of spec", true, orm.Map{"p1": 2.5, "p2": 1.0}, specs, false, true, orm.Map{"p1": "NG", "p2": "OK"}},
+		{"missing point", true, orm.Map{"p1": 1.5, "p2": nil}, specs, false, true, orm.Map{"p1": "OK", "p2": "NG"}},
+		{"device NG but in spec", false, orm.Map{"p1": 1.0, "p2": 2.0}, specs, true, true, orm.Map{"p1": "OK", "p2": "OK"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			product := &orm.Product{Qualified: c.qualified, PointValues: c.pointValues}
+			judgeProduct(product, c.specs)
+			if product.Qualified != c.want || product.QualifiedMismatch != c.mismatch {
+				t.Errorf("qualified, mismatch = (%v, %v), want (%v, %v)", product.Qualified, product.QualifiedMismatch, c.want, c.mismatch)
+			}
+			if len(product.PointJudgements) != len(c.judgements) {
+				t.Fatalf("judgements = %v, want %v", product.PointJudgements, c.judgements)
+			}
+			for name, judgement := range c.judgements {
+				if product.PointJudgements[name] != judgement {
+					t.Errorf("judgement of %s = %v, want %v", name, product.PointJudgements[name], judgement)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+// openTestDB 在临时目录中创建 SQLite 数据库并执行全部迁移，时钟固定为 now
+func openTestDB(t *testing.T, now time.Time) {
+	t.Helper()
+	clock.Set(clock.NewFixedClock(now))
+	t.Cleanup(func() { clock.Set(nil) })
+
+	if _, err := orm.Open(orm.Options{Driver: orm.DialectSQLite, Name: filepath.Join(t.TempDir(), "test.db")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = orm.Close() })
+	if _, err := orm.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestDevice 创建实时设备及其料号的当前版本
+func createTestDevice(t *testing.T, materialID uint) (*orm.Device, *orm.MaterialVersion) {
+	t.Helper()
+	version := orm.MaterialVersion{Version: "v1", MaterialID: materialID, Active: true}
+	if err := orm.DB.Create(&version).Error; err != nil {
+		t.Fatal(err)
+	}
+	device := orm.Device{
+		UUID:       fmt.Sprintf("device-%v", materialID),
+		Name:       "device",
+		Remark:     "device",
+		MaterialID: materialID,
+		IsRealtime: true,
+	}
+	if err := orm.DB.Create(&device).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &device, &version
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// measuredAtLayouts 支持的检测时间格式，不带时区的格式按工厂时区解析
+var measuredAtLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.000",
+	"2006/01/02 15:04:05",
+}
+
+// ParseMeasuredAt 解析设备上传的检测时间，支持上述格式及秒、毫秒级的Unix时间戳，为空时返回零值
+func ParseMeasuredAt(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// 超过 1e12 视为毫秒
+		if ts > 1e12 {
+			return time.Unix(0, ts*int64(time.Millisecond)).In(clock.Location()), nil
+		}
+		return time.Unix(ts, 0).In(clock.Location()), nil
+	}
+	for _, layout := range measuredAtLayouts {
+		if t, err := time.ParseInLocation(layout, value, clock.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse measured_at %s", value)
+}
+
+// measuredAt 校验检测时间是否在允许的偏差范围内，未上传检测时间时为当前时间，允许偏差为0时不限制
+func (s *ProductService) measuredAt(input *ProduceInput) (time.Time, error) {
+	now := clock.Now()
+	if input.MeasuredAt.IsZero() {
+		return now, nil
+	}
+
+	at := input.MeasuredAt.In(clock.Location())
+	if s.options.MaxMeasuredDelay > 0 && now.Sub(at) > s.options.MaxMeasuredDelay {
+		return at, &Error{
+			Code:    ErrorCodeInvalidInput,
+			Message: "检测时间过早.",
+			Origin:  fmt.Errorf("measured_at %v is more than %v before now", at, s.options.MaxMeasuredDelay),
+		}
+	}
+	if s.options.MaxMeasuredAhead > 0 && at.Sub(now) > s.options.MaxMeasuredAhead {
+		return at, &Error{
+			Code:    ErrorCodeInvalidInput,
+			Message: "检测时间晚于当前时间.",
+			Origin:  fmt.Errorf("measured_at %v is more than %v after now", at, s.options.MaxMeasuredAhead),
+		}
+	}
+	return at, nil
+}
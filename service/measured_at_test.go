@@ -0,0 +1,88 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+)
+
+func TestParseMeasuredAt(t *testing.T) {
+	if err := clock.SetTimezone("Asia/Shanghai"); err != nil {
+		t.Fatal(err)
+	}
+	defer clock.SetTimezone("")
+
+	want := time.Date(2026, 10, 17, 8, 30, 0, 0, clock.Location())
+	for _, value := range []string{
+		"2026-10-17T08:30:00+08:00",
+		"2026-10-17T00:30:00Z",
+		"2026-10-17 08:30:00",
+		"2026/10/17 08:30:00",
+		" 2026-10-17T08:30:00 ",
+		"1792197000",
+		"1792197000000",
+	} {
+		got, err := ParseMeasuredAt(value)
+		if err != nil {
+			t.Errorf("parse %q failed: %v", value, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parse %q = %v, want %v", value, got, want)
+		}
+	}
+
+	if got, err := ParseMeasuredAt(""); err != nil || !got.IsZero() {
+		t.Errorf("parse empty = (%v, %v), want zero time", got, err)
+	}
+	if _, err := ParseMeasuredAt("yesterday"); err == nil {
+		t.Error("parse invalid value should fail")
+	}
+}
+
+func TestMeasuredAt(t *testing.T) {
+	now := time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location())
+	clock.Set(clock.NewFixedClock(now))
+	defer clock.Set(nil)
+
+	s := NewProductService(ProductServiceOptions{MaxMeasuredDelay: time.Hour, MaxMeasuredAhead: time.Minute})
+	cases := []struct {
+		measuredAt time.Time
+		want       time.Time
+		invalid    bool
+	}{
+		{time.Time{}, now, false},
+		{now.Add(-30 * time.Minute), now.Add(-30 * time.Minute), false},
+		{now.Add(-2 * time.Hour), time.Time{}, true},
+		{now.Add(30 * time.Second), now.Add(30 * time.Second), false},
+		{now.Add(2 * time.Minute), time.Time{}, true},
+	}
+	for _, c := range cases {
+		got, err := s.measuredAt(&ProduceInput{MeasuredAt: c.measuredAt})
+		if c.invalid {
+			if e, ok := err.(*Error); !ok || e.Code != ErrorCodeInvalidInput {
+				t.Errorf("measured_at %v error = %v, want invalid input", c.measuredAt, err)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(c.want) {
+			t.Errorf("measured_at %v = (%v, %v), want %v", c.measuredAt, got, err, c.want)
+		}
+	}
+}
+
+func TestMeasuredAtUnlimited(t *testing.T) {
+	now := time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location())
+	clock.Set(clock.NewFixedClock(now))
+	defer clock.Set(nil)
+
+	// 未配置允许偏差时不限制检测时间
+	s := NewProductService(ProductServiceOptions{})
+	for _, at := range []time.Time{now.AddDate(-1, 0, 0), now.Add(-time.Second), now.Add(time.Second), now.AddDate(0, 0, 1)} {
+		got, err := s.measuredAt(&ProduceInput{MeasuredAt: at})
+		if err != nil || !got.Equal(at) {
+			t.Errorf("measured_at %v = (%v, %v), want accepted", at, got, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PointValue 设备上传的点位检测值
+// Value 为原始值，为空表示缺失
+type PointValue struct {
+	Name  string
+	Value string
+	Unit  string
+}
+
+// PointValueError 点位检测值解析错误
+type PointValueError struct {
+	Name   string
+	Value  string
+	Reason string
+}
+
+func (e PointValueError) Error() string {
+	return fmt.Sprintf("point %s with value %q %s", e.Name, e.Value, e.Reason)
+}
+
+const (
+	PointValueReasonMissing = "is missing"
+	PointValueReasonInvalid = "is not a number"
+	PointValueReasonNaN     = "is NaN or Inf"
+)
+
+// ParsePointValuesString 拆分 k:v;k:v 格式的点位检测值
+func ParsePointValuesString(pointValuesStr string) []PointValue {
+	var values []PointValue
+	kValues := strings.Split(pointValuesStr, ";")
+	for _, item := range kValues {
+		sectors := strings.SplitN(item, ":", 2)
+		if len(sectors) < 2 {
+			continue
+		}
+		values = append(values, PointValue{
+			Name:  strings.TrimSpace(sectors[0]),
+			Value: strings.TrimSpace(sectors[1]),
+		})
+	}
+
+	return values
+}
+
+// parsePointValue 解析单个点位的检测值，无法得到有效数值时返回错误
+func parsePointValue(point PointValue) (float64, *PointValueError) {
+	if point.Value == "" {
+		return 0, &PointValueError{Name: point.Name, Value: point.Value, Reason: PointValueReasonMissing}
+	}
+	value, err := strconv.ParseFloat(point.Value, 64)
+	if err != nil {
+		return 0, &PointValueError{Name: point.Name, Value: point.Value, Reason: PointValueReasonInvalid}
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, &PointValueError{Name: point.Name, Value: point.Value, Reason: PointValueReasonNaN}
+	}
+
+	return value, nil
+}
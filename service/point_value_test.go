@@ -0,0 +1,53 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePointValuesString(t *testing.T) {
+	values := ParsePointValuesString(" p1 : 1.5;p2:;invalid; p3:a:b ;")
+	want := []PointValue{
+		{Name: "p1", Value: "1.5"},
+		{Name: "p2", Value: ""},
+		{Name: "p3", Value: "a:b"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+}
+
+func TestParsePointValues(t *testing.T) {
+	values, errs := parsePointValues([]PointValue{
+		{Name: "ok", Value: "1.5"},
+		{Name: "missing", Value: ""},
+		{Name: "invalid", Value: "abc"},
+		{Name: "nan", Value: "NaN"},
+		{Name: "", Value: "2"},
+	})
+
+	if values["ok"] != 1.5 {
+		t.Errorf("ok = %v, want 1.5", values["ok"])
+	}
+	for _, name := range []string{"missing", "invalid", "nan"} {
+		if value, ok := values[name]; !ok || value != nil {
+			t.Errorf("%s = (%v, %v), want stored as null", name, value, ok)
+		}
+	}
+	if len(values) != 4 {
+		t.Errorf("values = %v, point without name should be skipped", values)
+	}
+
+	var reasons = make(map[string]string)
+	for _, err := range errs {
+		reasons[err.Name] = err.Reason
+	}
+	want := map[string]string{
+		"missing": PointValueReasonMissing,
+		"invalid": PointValueReasonInvalid,
+		"nan":     PointValueReasonNaN,
+	}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("reasons = %v, want %v", reasons, want)
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"errors"
+	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
+
+// ProduceInput 设备上传的单条生产数据
+type ProduceInput struct {
+	DeviceToken string
+	IP          string // 设备当前地址，为空时不更新
+	BarCode     string
+	Qualified   bool
+	PointValues []PointValue
+	Attributes  string
+	MessageID   string    // 可选，设备生成的消息ID，重传时保持不变
+	MeasuredAt  time.Time // 可选，设备检测时间，为零值时以接收时间为准
+}
+
+// ProduceResult 单条生产数据的处理结果
+type ProduceResult struct {
+	Product     *orm.Product
+	Duplicate   bool              // 消息ID已上传过，Product 为原产品
+	PointErrors []PointValueError // 点位检测值解析错误，对应点位存储为null
+}
+
+// ProductService 产品数据接入服务
+// 处理设备查找、实时导入记录、条码解析、点位值解析、产品写入及计数，
+// 与具体的接入方式无关
+type ProductService struct {
+	options ProductServiceOptions
+	hooks   []ProductHook
+	writer  *productWriter // 启用缓冲写入时不为nil
+}
+
+type ProductServiceOptions struct {
+	MaxMeasuredDelay time.Duration // 检测时间早于接收时间的最大间隔，为0时不限制
+	MaxMeasuredAhead time.Duration // 检测时间晚于接收时间的最大间隔，为0时不限制
+	Buffer           BufferOptions // 产品缓冲写入配置
+}
+
+func NewProductService(options ProductServiceOptions) *ProductService {
+	s := &ProductService{options: options}
+	if options.Buffer.Enabled {
+		s.writer = newProductWriter(options.Buffer, s.afterProduce)
+	}
+	return s
+}
+
+// Start 启用缓冲写入时开始定时写入队列中的产品
+func (s *ProductService) Start() {
+	if s.writer != nil {
+		go s.writer.run()
+	}
+}
+
+// Stop 停止接收缓冲写入的产品，并等待队列中的产品写入完成
+// 应在各接入方式停止后、回调使用的统计及告警停止前调用
+func (s *ProductService) Stop() {
+	if s.writer != nil {
+		s.writer.close()
+	}
+}
+
+// Produce 处理设备上传的单条生产数据，写入产品并累加检测时间所在班次的实时导入记录
+func (s *ProductService) Produce(input *ProduceInput) (*ProduceResult, error) {
+	device, err := s.prepareDevice(input.DeviceToken, input.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	// 重传的消息直接返回原结果
+	if original := s.findDuplicate(device.ID, input.MessageID); original != nil {
+		return &ProduceResult{Product: original, Duplicate: true}, nil
+	}
+
+	measuredAt, err := s.measuredAt(input)
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.realtimeRecord(device, measuredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := s.pointSpecs(record)
+	product, pointErrors := buildProduct(input, device, record, device.GetCurrentTemplateDecodeRule(), specs)
+	product.CreatedAt = measuredAt
+	if s.writer != nil {
+		item := newPendingProduct(product, record, specs)
+		if !s.writer.enqueue(item) {
+			return nil, &Error{Code: ErrorCodeBusy, Message: "服务繁忙，请稍后重试.", Origin: errors.New("product buffer is full")}
+		}
+		// 等待产品随批次提交后再返回，调用方据此回复确认
+		if err := item.wait(); err != nil {
+			// 数据库可用时写入失败的产品才会返回错误，停止时未写入的产品可由设备重传
+			return nil, &Error{Code: ErrorCodeSaveProduct, Message: "保存产品信息失败.", Origin: err, temporary: err == errWriterStopped}
+		}
+		if item.original != nil {
+			return &ProduceResult{Product: item.original, Duplicate: true}, nil
+		}
+		return &ProduceResult{Product: product, PointErrors: pointErrors}, nil
+	}
+
+	// 产品写入与导入记录计数在同一事务中，保证导入记录的计数与产品数量一致
+	var qualified int
+	if product.Qualified {
+		qualified = 1
+	}
+	err = orm.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(product).Error; err != nil {
+			return err
+		}
+		return record.IncreaseCountTx(tx, 1, 1, qualified)
+	})
+	if err != nil {
+		// 并发重传时由唯一索引拦截
+		if original := findDuplicate(device.ID, input.MessageID); original != nil {
+			return &ProduceResult{Product: original, Duplicate: true}, nil
+		}
+		return nil, newStorageError(ErrorCodeSaveProduct, "保存产品信息失败.", err)
+	}
+	s.afterProduce(product, specs)
+	return &ProduceResult{Product: product, PointErrors: pointErrors}, nil
+}
+
+// prepareDevice 根据设备token获取设备，并更新设备IP
+func (s *ProductService) prepareDevice(deviceToken, ip string) (*orm.Device, error) {
+	var device orm.Device
+	if err := device.GetWithToken(deviceToken); err != nil {
+		return nil, newStorageError(ErrorCodeDeviceNotFound, "对不起，查找设备失败.", err)
+	}
+	if ip != "" && device.IP != ip {
+		device.IP = ip
+		_ = orm.DB.Save(&device)
+	}
+
+	return &device, nil
+}
+
+// realtimeRecord 获取设备在检测时间所在班次的实时导入记录
+func (s *ProductService) realtimeRecord(device *orm.Device, measuredAt time.Time) (*orm.ImportRecord, error) {
+	var record orm.ImportRecord
+	if err := record.GetDeviceRealtimeRecord(device, measuredAt); err != nil {
+		return nil, newStorageError(ErrorCodeRealtimeRecord, "获取设备实时导入记录失败.", err)
+	}
+
+	return &record, nil
+}
+
+// pointSpecs 获取导入记录对应料号版本的点位规格，获取失败时不做判定
+func (s *ProductService) pointSpecs(record *orm.ImportRecord) []orm.PointSpec {
+	specs, err := orm.GetPointSpecsWithVersionID(record.MaterialVersionID)
+	if err != nil {
+		log.Errorln(err)
+		return nil
+	}
+	return specs
+}
+
+// findDuplicate 查找设备已上传的相同消息ID的产品
+// 原产品在缓冲队列中时等待其写入完成，写入失败时按新数据处理
+func (s *ProductService) findDuplicate(deviceID uint, messageID string) *orm.Product {
+	if s.writer != nil {
+		if item := s.writer.pending(deviceID, strings.TrimSpace(messageID)); item != nil && item.wait() == nil {
+			if item.original != nil {
+				return item.original
+			}
+			return item.product
+		}
+	}
+	return findDuplicate(deviceID, messageID)
+}
+
+// findDuplicate 查找设备已上传的相同消息ID的产品，消息ID为空或未上传过时返回nil
+func findDuplicate(deviceID uint, messageID string) *orm.Product {
+	messageID = strings.TrimSpace(messageID)
+	if messageID == "" {
+		return nil
+	}
+
+	var product orm.Product
+	if err := product.GetWithMessageID(deviceID, messageID); err != nil {
+		return nil
+	}
+	return &product
+}
+
+// buildProduct 将上传的数据解析为产品对象，同时返回点位检测值的解析错误
+func buildProduct(input *ProduceInput, device *orm.Device, record *orm.ImportRecord, rule *orm.BarCodeRule, specs []orm.PointSpec) (*orm.Product, []PointValueError) {
+	var attribute orm.Map
+	var statusCode = orm.BarCodeStatusSuccess
+
+	barCode := strings.TrimSpace(input.BarCode)
+	if rule != nil {
+		if decoder := orm.NewBarCodeDecoder(rule); decoder != nil {
+			attribute, statusCode = decoder.Decode(barCode)
+		} else {
+			// 编码规则没有解析项或配置错误
+			attribute, statusCode = make(orm.Map), orm.BarCodeStatusNoRule
+		}
+	} else {
+		attribute = make(orm.Map)
+	}
+
+	var messageID *string
+	if id := strings.TrimSpace(input.MessageID); id != "" {
+		messageID = &id
+	}
+
+	pointValues, pointErrors := parsePointValues(input.PointValues)
+	product := &orm.Product{
+		MessageID:          messageID,
+		MaterialID:         device.MaterialID,
+		DeviceID:           device.ID,
+		Qualified:          input.Qualified,
+		Attribute:          attribute,
+		PointValues:        pointValues,
+		PointValuesInvalid: len(pointErrors) > 0,
+		ImportRecordID:     record.ID,
+		MaterialVersionID:  record.MaterialVersionID,
+		BarCode:            barCode,
+		BarCodeStatus:      statusCode,
+	}
+	judgeProduct(product, specs)
+	return product, pointErrors
+}
+
+// parsePointValues 解析点位检测值，缺失或非法的值存储为null
+func parsePointValues(points []PointValue) (orm.Map, []PointValueError) {
+	pointValues := make(orm.Map)
+	var pointErrors []PointValueError
+	for _, point := range points {
+		if point.Name == "" {
+			continue
+		}
+		value, err := parsePointValue(point)
+		if err != nil {
+			pointValues[point.Name] = nil
+			pointErrors = append(pointErrors, *err)
+			continue
+		}
+		pointValues[point.Name] = value
+	}
+
+	return pointValues, pointErrors
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"strings"
+)
+
+// ProduceBatchInput 同一设备批量上传的生产数据
+// Items 中的 DeviceToken 为空时视为与批次相同，不同时拒绝该条数据
+type ProduceBatchInput struct {
+	DeviceToken string
+	IP          string
+	Items       []ProduceInput
+}
+
+// ProduceBatchItemResult 批量上传中单条数据的处理结果
+type ProduceBatchItemResult struct {
+	Accepted      bool
+	Reason        string // 拒绝原因
+	Duplicate     bool   // 消息ID已上传过，返回原结果
+	BarCodeStatus int
+	Product       *orm.Product
+	PointErrors   []PointValueError
+}
+
+type ProduceBatchResult struct {
+	Accepted int
+	Rejected int
+	Items    []ProduceBatchItemResult
+}
+
+// recordGroup 同一实时导入记录下的产品
+type recordGroup struct {
+	record    *orm.ImportRecord
+	specs     []orm.PointSpec
+	count     int
+	qualified int
+}
+
+// ProduceBatch 批量处理同一设备上传的生产数据
+// 产品在同一事务中以多行 INSERT 写入，不经过缓冲队列，按检测时间所在班次分配实时导入记录，每条记录只累加一次
+func (s *ProductService) ProduceBatch(input *ProduceBatchInput) (*ProduceBatchResult, error) {
+	device, err := s.prepareDevice(input.DeviceToken, input.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := device.GetCurrentTemplateDecodeRule()
+	var result = ProduceBatchResult{Items: make([]ProduceBatchItemResult, len(input.Items))}
+	var products []*orm.Product
+	var indexes []int
+	var groups = make(map[uint]*recordGroup)    // 导入记录ID -> 产品分组
+	var duplicates = make(map[int]*orm.Product) // 重传数据的索引 -> 原产品
+	var messages = make(map[string]*orm.Product)
+	for idx := range input.Items {
+		item := &input.Items[idx]
+		if item.DeviceToken != "" && item.DeviceToken != input.DeviceToken {
+			result.Items[idx].Reason = "device token mismatch"
+			result.Rejected++
+			continue
+		}
+
+		messageID := strings.TrimSpace(item.MessageID)
+		if original, ok := messages[messageID]; ok {
+			duplicates[idx] = original
+			continue
+		}
+		if original := s.findDuplicate(device.ID, messageID); original != nil {
+			duplicates[idx] = original
+			continue
+		}
+
+		measuredAt, err := s.measuredAt(item)
+		if err != nil {
+			result.Items[idx].Reason = err.Error()
+			result.Rejected++
+			continue
+		}
+		record, err := s.realtimeRecord(device, measuredAt)
+		if err != nil {
+			return nil, err
+		}
+		group, ok := groups[record.ID]
+		if !ok {
+			group = &recordGroup{record: record, specs: s.pointSpecs(record)}
+			groups[record.ID] = group
+		}
+
+		product, pointErrors := buildProduct(item, device, group.record, rule, group.specs)
+		product.CreatedAt = measuredAt
+		group.count++
+		if product.Qualified {
+			group.qualified++
+		}
+		if messageID != "" {
+			messages[messageID] = product
+		}
+		products = append(products, product)
+		indexes = append(indexes, idx)
+		result.Items[idx].PointErrors = pointErrors
+	}
+
+	// 计数在写入产品的事务中累加，任一步失败时整批回滚；按记录ID顺序累加，避免并发批次相互等待
+	var recordIDs []uint
+	for id := range groups {
+		recordIDs = append(recordIDs, id)
+	}
+	sort.Slice(recordIDs, func(i, j int) bool { return recordIDs[i] < recordIDs[j] })
+	if err := orm.DB.Transaction(func(tx *gorm.DB) error {
+		if err := orm.CreateProducts(tx, products); err != nil {
+			return err
+		}
+		for _, id := range recordIDs {
+			group := groups[id]
+			if err := group.record.IncreaseCountTx(tx, group.count, group.count, group.qualified); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, newStorageError(ErrorCodeSaveProduct, "保存产品信息失败.", err)
+	}
+
+	for i, product := range products {
+		item := &result.Items[indexes[i]]
+		item.Accepted = true
+		item.BarCodeStatus = product.BarCodeStatus
+		item.Product = product
+		result.Accepted++
+	}
+	for idx, original := range duplicates {
+		result.Items[idx] = ProduceBatchItemResult{
+			Accepted:      true,
+			Duplicate:     true,
+			BarCodeStatus: original.BarCodeStatus,
+			Product:       original,
+		}
+		result.Accepted++
+	}
+	for _, product := range products {
+		s.afterProduce(product, groups[product.ImportRecordID].specs)
+	}
+	return &result, nil
+}
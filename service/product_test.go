@@ -0,0 +1,137 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+// TestProduceConcurrentCounts 同一设备并发上传，同时关闭实时导入记录，
+// 导入记录及料号版本的计数应与产品表一致
+func TestProduceConcurrentCounts(t *testing.T) {
+	for _, buffer := range []BufferOptions{
+		{},
+		{Enabled: true, FlushInterval: 10 * time.Millisecond, FlushSize: 16},
+	} {
+		t.Run(fmt.Sprintf("buffer=%v", buffer.Enabled), func(t *testing.T) {
+			openTestDB(t, time.Date(2026, 10, 17, 10, 0, 0, 0, clock.Location()))
+			device, version := createTestDevice(t, 1)
+
+			s := NewProductService(ProductServiceOptions{MaxMeasuredDelay: time.Hour, MaxMeasuredAhead: time.Hour, Buffer: buffer})
+			s.Start()
+			defer s.Stop()
+
+			const workers, perWorker = 8, 25
+			var wg sync.WaitGroup
+			var stop = make(chan struct{})
+			var rollover = make(chan struct{})
+			go func() {
+				defer close(rollover)
+				for {
+					select {
+					case <-stop:
+						return
+					case <-time.After(5 * time.Millisecond):
+						if _, err := orm.CloseStaleRealtimeRecords(clock.Now().Add(time.Hour)); err != nil {
+							t.Error(err)
+						}
+					}
+				}
+			}()
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						_, err := s.Produce(&ProduceInput{
+							DeviceToken: device.UUID,
+							BarCode:     fmt.Sprintf("%v-%v", w, i),
+							Qualified:   i%3 != 0,
+							MessageID:   fmt.Sprintf("%v-%v", w, i),
+						})
+						if err != nil {
+							t.Error(err)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(stop)
+			<-rollover
+			if _, err := orm.CloseStaleRealtimeRecords(clock.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+
+			var total, qualified int
+			orm.DB.Model(&orm.Product{}).Where("device_id = ?", device.ID).Count(&total)
+			orm.DB.Model(&orm.Product{}).Where("device_id = ? AND qualified = ?", device.ID, true).Count(&qualified)
+			if total != workers*perWorker {
+				t.Fatalf("products = %v, want %v", total, workers*perWorker)
+			}
+
+			var records []orm.ImportRecord
+			if err := orm.DB.Where("device_id = ?", device.ID).Find(&records).Error; err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range records {
+				var count, qualifiedCount int
+				orm.DB.Model(&orm.Product{}).Where("import_record_id = ?", record.ID).Count(&count)
+				orm.DB.Model(&orm.Product{}).Where("import_record_id = ? AND qualified = ?", record.ID, true).Count(&qualifiedCount)
+				if record.RowCount != count || record.RowFinishedCount != count || record.QualifiedCount != qualifiedCount {
+					t.Errorf("import_record %v counts = (%v, %v, %v), want (%v, %v, %v)", record.ID,
+						record.RowCount, record.RowFinishedCount, record.QualifiedCount, count, count, qualifiedCount)
+				}
+				if record.Status != orm.ImportStatusFinished {
+					t.Errorf("import_record %v status = %v, want %v", record.ID, record.Status, orm.ImportStatusFinished)
+				}
+			}
+
+			if err := version.Get(version.ID); err != nil {
+				t.Fatal(err)
+			}
+			if version.Amount != total || version.QualifiedCount != qualified {
+				t.Errorf("material_version counts = (%v, %v), want (%v, %v)", version.Amount, version.QualifiedCount, total, qualified)
+			}
+		})
+	}
+}
+
+func TestBuildProduct(t *testing.T) {
+	device := &orm.Device{MaterialID: 3}
+	device.ID = 2
+	record := &orm.ImportRecord{MaterialVersionID: 4}
+	record.ID = 5
+	specs := []orm.PointSpec{{Name: "p1", USL: float(2)}}
+
+	product, pointErrors := buildProduct(&ProduceInput{
+		BarCode:     " code-1 ",
+		Qualified:   true,
+		MessageID:   " message-1 ",
+		PointValues: []PointValue{{Name: "p1", Value: "3"}, {Name: "p2", Value: "x"}},
+	}, device, record, nil, specs)
+
+	if product.BarCode != "code-1" || product.BarCodeStatus != orm.BarCodeStatusSuccess {
+		t.Errorf("bar code = (%q, %v)", product.BarCode, product.BarCodeStatus)
+	}
+	if product.MessageID == nil || *product.MessageID != "message-1" {
+		t.Errorf("message id = %v, want message-1", product.MessageID)
+	}
+	if product.DeviceID != 2 || product.MaterialID != 3 || product.MaterialVersionID != 4 || product.ImportRecordID != 5 {
+		t.Errorf("product ids = (%v, %v, %v, %v)", product.DeviceID, product.MaterialID, product.MaterialVersionID, product.ImportRecordID)
+	}
+	if !product.PointValuesInvalid || len(pointErrors) != 1 || pointErrors[0].Name != "p2" {
+		t.Errorf("point errors = (%v, %v)", product.PointValuesInvalid, pointErrors)
+	}
+	if product.Qualified || !product.QualifiedMismatch {
+		t.Errorf("qualified, mismatch = (%v, %v), want (false, true)", product.Qualified, product.QualifiedMismatch)
+	}
+
+	product, _ = buildProduct(&ProduceInput{MessageID: "  "}, device, record, nil, nil)
+	if product.MessageID != nil {
+		t.Errorf("blank message id = %v, want nil", *product.MessageID)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"fmt"
+	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"time"
+)
+
+// RolloverScheduler 实时导入记录的日切任务
+// 每天在切换时间及每个班次结束时，关闭之前班次仍在导入中的实时导入记录，
+// 并累加到料号版本的总数及良率，避免空闲设备的记录一直处于导入中；启动时会先执行一次
+// 记录通过带状态条件的更新关闭，多个实例同时执行时不会重复累加
+type RolloverScheduler struct {
+	cutover time.Duration // 切换时间距零点的偏移
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewRolloverScheduler cutover 为每天的切换时间，格式为 HH:MM
+func NewRolloverScheduler(cutover string) (*RolloverScheduler, error) {
+	t, err := time.Parse("15:04", cutover)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rollover cutover %s: %v", cutover, err)
+	}
+
+	return &RolloverScheduler{
+		cutover: time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute,
+	}, nil
+}
+
+// Rollover 关闭当前班次之前创建的实时导入记录
+func (s *RolloverScheduler) Rollover() {
+	period := orm.CurrentShiftPeriod(clock.Now())
+	closed, err := orm.CloseStaleRealtimeRecords(period.Start)
+	if err != nil {
+		log.Error("rollover realtime import records failed: %v", err)
+		return
+	}
+	if closed > 0 {
+		log.Info("rollover closed %v realtime import records", closed)
+	}
+}
+
+// next 下一次执行的时间，为下一个切换时间与当前班次结束时间中较早的一个
+func (s *RolloverScheduler) next(now time.Time) time.Time {
+	now = now.In(clock.Location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := today.Add(s.cutover)
+	if !next.After(now) {
+		next = today.AddDate(0, 0, 1).Add(s.cutover)
+	}
+	if end := orm.CurrentShiftPeriod(now).End; end.Before(next) {
+		next = end
+	}
+	return next
+}
+
+func (s *RolloverScheduler) Start() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		s.Rollover()
+		for {
+			now := clock.Now()
+			timer := time.NewTimer(s.next(now).Sub(now))
+			select {
+			case <-timer.C:
+				s.Rollover()
+			case <-s.stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (s *RolloverScheduler) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BufferOptions 产品缓冲写入配置
+type BufferOptions struct {
+	Enabled       bool          // 是否启用缓冲写入，不启用时每条数据同步写入
+	FlushInterval time.Duration // 定时写入的间隔
+	FlushSize     int           // 缓冲的产品达到该数量时立即写入
+	QueueSize     int           // 缓冲队列容量，队列满时拒绝接收
+}
+
+// errWriterStopped 停止时仍未写入成功的产品返回的错误
+var errWriterStopped = errors.New("product writer stopped before the product was saved")
+
+// pendingProduct 等待写入的产品
+// 写入完成后关闭 done，err 为写入失败的原因，original 为已写入的重传原产品
+type pendingProduct struct {
+	product *orm.Product
+	record  *orm.ImportRecord
+	specs   []orm.PointSpec
+
+	done     chan struct{}
+	err      error
+	original *orm.Product
+}
+
+func newPendingProduct(product *orm.Product, record *orm.ImportRecord, specs []orm.PointSpec) *pendingProduct {
+	return &pendingProduct{product: product, record: record, specs: specs, done: make(chan struct{})}
+}
+
+// wait 等待产品写入完成
+func (p *pendingProduct) wait() error {
+	<-p.done
+	return p.err
+}
+
+// productWriter 产品缓冲写入
+// 产品先进入内存队列，按间隔或数量批量写入产品表，并在同一事务中累加实时导入记录的计数，
+// 提交后才通知等待的调用方，各接入方式在此之后才回复确认
+// 数据库不可用时写入失败的产品保留在内存中重试，重试期间不再从队列接收新的产品，队列满时拒绝接收
+type productWriter struct {
+	options    BufferOptions
+	queue      chan *pendingProduct
+	afterFlush func(product *orm.Product, specs []orm.PointSpec)
+
+	mu       sync.Mutex                 // 保护 messages，并保证停止后不再有产品进入队列
+	messages map[string]*pendingProduct // 未写入完成的带消息ID的产品，用于重传去重
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newProductWriter(options BufferOptions, afterFlush func(*orm.Product, []orm.PointSpec)) *productWriter {
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = 200 * time.Millisecond
+	}
+	if options.FlushSize <= 0 {
+		options.FlushSize = 500
+	}
+	if options.QueueSize < options.FlushSize {
+		options.QueueSize = options.FlushSize
+	}
+
+	return &productWriter{
+		options:    options,
+		queue:      make(chan *pendingProduct, options.QueueSize),
+		afterFlush: afterFlush,
+		messages:   make(map[string]*pendingProduct),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func messageKey(deviceID uint, messageID string) string {
+	return fmt.Sprintf("%v/%s", deviceID, messageID)
+}
+
+// pending 获取设备以指定消息ID上传、尚未写入完成的产品
+func (w *productWriter) pending(deviceID uint, messageID string) *pendingProduct {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.messages[messageKey(deviceID, messageID)]
+}
+
+// enqueue 将产品加入队列，队列已满或已停止时返回false
+func (w *productWriter) enqueue(item *pendingProduct) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	select {
+	case <-w.stop:
+		return false
+	default:
+	}
+
+	select {
+	case w.queue <- item:
+		if item.product.MessageID != nil {
+			w.messages[messageKey(item.product.DeviceID, *item.product.MessageID)] = item
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// complete 通知等待的调用方写入完成，并移除重传去重的记录
+func (w *productWriter) complete(items []*pendingProduct, err error) {
+	w.mu.Lock()
+	for _, item := range items {
+		if item.product.MessageID != nil {
+			key := messageKey(item.product.DeviceID, *item.product.MessageID)
+			if w.messages[key] == item {
+				delete(w.messages, key)
+			}
+		}
+	}
+	w.mu.Unlock()
+
+	for _, item := range items {
+		item.err = err
+		close(item.done)
+	}
+}
+
+func (w *productWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.options.FlushInterval)
+	defer ticker.Stop()
+
+	var batch, retry []*pendingProduct
+	for {
+		// 有待重试的产品时只按间隔重试，新的产品留在队列中
+		queue := w.queue
+		if len(retry) > 0 {
+			queue = nil
+		}
+
+		select {
+		case item := <-queue:
+			batch = append(batch, item)
+			if len(batch) >= w.options.FlushSize {
+				retry = w.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			retry = w.flush(append(retry, batch...))
+			batch = nil
+		case <-w.stop:
+			// 写入剩余的产品，仍然失败的产品返回错误给调用方
+			batch = append(retry, batch...)
+			for {
+				select {
+				case item := <-w.queue:
+					batch = append(batch, item)
+					continue
+				default:
+				}
+				break
+			}
+			for start := 0; start < len(batch); start += w.options.FlushSize {
+				end := start + w.options.FlushSize
+				if end > len(batch) {
+					end = len(batch)
+				}
+				if failed := w.flush(batch[start:end]); len(failed) > 0 {
+					log.Error("drop %d buffered products on stop, devices will resend them", len(failed))
+					w.complete(failed, errWriterStopped)
+				}
+			}
+			return
+		}
+	}
+}
+
+// flush 在同一事务中批量写入产品并累加导入记录计数
+// 批量写入失败时逐条写入，返回因数据库不可用需要重试的产品
+func (w *productWriter) flush(batch []*pendingProduct) []*pendingProduct {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var products = make([]*orm.Product, len(batch))
+	for idx, item := range batch {
+		products[idx] = item.product
+	}
+	err := orm.DB.Transaction(func(tx *gorm.DB) error {
+		if err := orm.CreateProducts(tx, products); err != nil {
+			return err
+		}
+		return increaseRecords(tx, batch)
+	})
+	if err != nil {
+		log.Error("flush %d buffered products failed, write one by one: %v", len(batch), err)
+		return w.flushOneByOne(batch)
+	}
+
+	w.complete(batch, nil)
+	for _, item := range batch {
+		w.afterFlush(item.product, item.specs)
+	}
+	return nil
+}
+
+// flushOneByOne 逐条在事务中写入产品及累加计数
+// 重传数据返回已写入的原产品；数据库可用时写入失败视为该产品的错误，返回给调用方，
+// 数据库不可用时保留产品，返回待重试的产品
+func (w *productWriter) flushOneByOne(batch []*pendingProduct) []*pendingProduct {
+	var retry []*pendingProduct
+	for _, item := range batch {
+		// 批量写入回滚前可能已回填ID
+		item.product.ID = 0
+		err := orm.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(item.product).Error; err != nil {
+				return err
+			}
+			return increaseRecords(tx, []*pendingProduct{item})
+		})
+		if err == nil {
+			w.complete([]*pendingProduct{item}, nil)
+			w.afterFlush(item.product, item.specs)
+			continue
+		}
+
+		if item.product.MessageID != nil {
+			if original := findDuplicate(item.product.DeviceID, *item.product.MessageID); original != nil {
+				item.original = original
+				w.complete([]*pendingProduct{item}, nil)
+				continue
+			}
+		}
+		if databaseUnavailable() {
+			retry = append(retry, item)
+			continue
+		}
+		log.Error("save buffered product %s of device %v failed: %v", item.product.BarCode, item.product.DeviceID, err)
+		w.complete([]*pendingProduct{item}, err)
+	}
+
+	if len(retry) > 0 {
+		log.Error("database is unavailable, retry %d buffered products later", len(retry))
+	}
+	return retry
+}
+
+// increaseRecords 按实时导入记录汇总累加计数，按记录ID顺序加锁
+func increaseRecords(db *gorm.DB, batch []*pendingProduct) error {
+	var groups = make(map[uint]*recordGroup)
+	var ids []uint
+	for _, item := range batch {
+		group, ok := groups[item.record.ID]
+		if !ok {
+			group = &recordGroup{record: item.record}
+			groups[item.record.ID] = group
+			ids = append(ids, item.record.ID)
+		}
+		group.count++
+		if item.product.Qualified {
+			group.qualified++
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		group := groups[id]
+		if err := group.record.IncreaseCountTx(db, group.count, group.count, group.qualified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close 停止接收产品，写入队列中剩余的产品后返回
+func (w *productWriter) close() {
+	w.stopOnce.Do(func() {
+		w.mu.Lock()
+		close(w.stop)
+		w.mu.Unlock()
+	})
+	<-w.done
+}
@@ -0,0 +1,306 @@
+package spc
+
+import (
+	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"math"
+	"sync"
+	"time"
+)
+
+// 单值控制图判异规则（Nelson rules）
+const (
+	RuleBeyondLimits   = 1 + iota // 1点超出3σ控制限
+	RuleRunOneSide                // 连续7点位于中心线同一侧
+	RuleTrend                     // 连续6点递增或递减
+	RuleAlternating               // 连续14点上下交替
+	RuleTwoOfThree                // 连续3点中有2点位于同侧2σ以外
+	RuleFourOfFive                // 连续5点中有4点位于同侧1σ以外
+	RuleStratification            // 连续15点位于中心线两侧1σ以内
+	RuleMixture                   // 连续8点位于中心线两侧1σ以外
+)
+
+var ruleDescriptions = map[int]string{
+	RuleBeyondLimits:   "1 point beyond 3 sigma",
+	RuleRunOneSide:     "7 points in a row on one side of center line",
+	RuleTrend:          "6 points in a row increasing or decreasing",
+	RuleAlternating:    "14 points in a row alternating up and down",
+	RuleTwoOfThree:     "2 out of 3 points beyond 2 sigma on the same side",
+	RuleFourOfFive:     "4 out of 5 points beyond 1 sigma on the same side",
+	RuleStratification: "15 points in a row within 1 sigma",
+	RuleMixture:        "8 points in a row beyond 1 sigma on both sides",
+}
+
+// seriesLength 判异规则需要的最大连续点数
+const seriesLength = 15
+
+// limitsTTL 由历史统计量推算的控制限的缓存时间
+const limitsTTL = 10 * time.Minute
+
+// ControlLimits 单值控制图的控制限
+type ControlLimits struct {
+	CenterLine float64
+	UCL        float64
+	LCL        float64
+}
+
+// sigma 将检测值转换为距中心线的σ距离，上下控制限不对称时分别计算
+func (l ControlLimits) sigma(value float64) float64 {
+	if value >= l.CenterLine {
+		if l.UCL <= l.CenterLine {
+			return 0
+		}
+		return (value - l.CenterLine) / ((l.UCL - l.CenterLine) / 3)
+	}
+	if l.LCL >= l.CenterLine {
+		return 0
+	}
+	return (value - l.CenterLine) / ((l.CenterLine - l.LCL) / 3)
+}
+
+type seriesKey struct {
+	versionID uint
+	deviceID  uint
+	pointName string
+}
+
+// series 设备点位最近的检测值序列，active 记录各规则当前是否处于违规状态
+type series struct {
+	sigmas []float64
+	active map[int]bool
+}
+
+// derivedLimits 由历史统计量推算的控制限，过期后异步刷新，刷新完成前继续使用过期的值
+type derivedLimits struct {
+	limits     map[string]ControlLimits
+	expiredAt  time.Time
+	refreshing bool
+}
+
+// ControlChart 单值控制图判异
+// 产品写入后按设备及点位判断最近的检测值序列是否违反判异规则，
+// 规则由满足变为违反时记录违规事件并通知订阅者
+// 控制限优先使用点位规格中配置的值，未配置时由料号版本的历史统计量推算
+type ControlChart struct {
+	engine     *Engine
+	minSamples int // 推算控制限需要的最少样本数量
+
+	mu          sync.Mutex
+	series      map[seriesKey]*series
+	subscribers []func(violation *orm.ControlViolation)
+
+	limitsMu sync.Mutex
+	derived  map[uint]*derivedLimits
+	wg       sync.WaitGroup // 进行中的控制限刷新
+}
+
+func NewControlChart(engine *Engine, minSamples int) *ControlChart {
+	return &ControlChart{
+		engine:     engine,
+		minSamples: minSamples,
+		series:     make(map[seriesKey]*series),
+		derived:    make(map[uint]*derivedLimits),
+	}
+}
+
+// Subscribe 订阅违规事件，应在开始接收数据前调用
+func (cc *ControlChart) Subscribe(fn func(violation *orm.ControlViolation)) {
+	cc.subscribers = append(cc.subscribers, fn)
+}
+
+// AfterProduce 对产品各点位的检测值执行判异
+func (cc *ControlChart) AfterProduce(product *orm.Product, specs []orm.PointSpec) {
+	var specMap = make(map[string]*orm.PointSpec)
+	for i := range specs {
+		specMap[specs[i].Name] = &specs[i]
+	}
+
+	var violations []*orm.ControlViolation
+	for name, v := range product.PointValues {
+		value, ok := v.(float64)
+		if !ok {
+			continue
+		}
+		limits, ok := cc.limits(product.MaterialVersionID, name, specMap[name])
+		if !ok {
+			continue
+		}
+
+		key := seriesKey{versionID: product.MaterialVersionID, deviceID: product.DeviceID, pointName: name}
+		cc.mu.Lock()
+		s, ok := cc.series[key]
+		if !ok {
+			s = &series{active: make(map[int]bool)}
+			cc.series[key] = s
+		}
+		rules := s.push(limits.sigma(value))
+		cc.mu.Unlock()
+
+		for _, rule := range rules {
+			violations = append(violations, &orm.ControlViolation{
+				MaterialVersionID: product.MaterialVersionID,
+				DeviceID:          product.DeviceID,
+				ProductID:         product.ID,
+				PointName:         name,
+				Rule:              rule,
+				Description:       ruleDescriptions[rule],
+				Value:             value,
+				CenterLine:        limits.CenterLine,
+				UCL:               limits.UCL,
+				LCL:               limits.LCL,
+			})
+		}
+	}
+
+	for _, violation := range violations {
+		if err := orm.DB.Create(violation).Error; err != nil {
+			log.Error("save control violation failed: %v", err)
+		}
+		for _, fn := range cc.subscribers {
+			fn(violation)
+		}
+	}
+}
+
+// limits 获取点位的控制限
+func (cc *ControlChart) limits(versionID uint, pointName string, spec *orm.PointSpec) (ControlLimits, bool) {
+	if spec != nil && spec.UCL != nil && spec.LCL != nil {
+		var limits = ControlLimits{UCL: *spec.UCL, LCL: *spec.LCL, CenterLine: (*spec.UCL + *spec.LCL) / 2}
+		if spec.CenterLine != nil {
+			limits.CenterLine = *spec.CenterLine
+		}
+		return limits, true
+	}
+
+	// 推算控制限需要查询统计量，不在写入产品的过程中等待
+	cc.limitsMu.Lock()
+	defer cc.limitsMu.Unlock()
+	derived, ok := cc.derived[versionID]
+	if !ok {
+		derived = &derivedLimits{}
+		cc.derived[versionID] = derived
+	}
+	if !derived.refreshing && time.Now().After(derived.expiredAt) {
+		derived.refreshing = true
+		cc.wg.Add(1)
+		go cc.refresh(versionID)
+	}
+
+	limits, ok := derived.limits[pointName]
+	return limits, ok
+}
+
+// refresh 由料号版本的历史统计量重新推算控制限，查询失败时保留原有的控制限
+func (cc *ControlChart) refresh(versionID uint) {
+	defer cc.wg.Done()
+
+	var limits map[string]ControlLimits
+	results, err := cc.engine.Query(orm.SPCStatQuery{MaterialVersionID: versionID})
+	if err != nil {
+		log.Error("derive control limits of version %v failed: %v", versionID, err)
+	} else {
+		limits = make(map[string]ControlLimits)
+		for _, result := range results {
+			if result.Count < cc.minSamples || result.SigmaWithin == nil || *result.SigmaWithin == 0 {
+				continue
+			}
+			limits[result.PointName] = ControlLimits{
+				CenterLine: result.Mean,
+				UCL:        result.Mean + 3**result.SigmaWithin,
+				LCL:        result.Mean - 3**result.SigmaWithin,
+			}
+		}
+	}
+
+	cc.limitsMu.Lock()
+	defer cc.limitsMu.Unlock()
+	derived := cc.derived[versionID]
+	if limits != nil {
+		derived.limits = limits
+	}
+	derived.expiredAt = time.Now().Add(limitsTTL)
+	derived.refreshing = false
+}
+
+// Stop 等待进行中的控制限刷新完成，应在停止 Engine 及关闭数据库之前调用
+func (cc *ControlChart) Stop() {
+	cc.wg.Wait()
+}
+
+// push 追加检测值并返回由满足变为违反的规则，超出3σ的规则每次违反都返回
+func (s *series) push(sigma float64) []int {
+	s.sigmas = append(s.sigmas, sigma)
+	if len(s.sigmas) > seriesLength {
+		s.sigmas = s.sigmas[len(s.sigmas)-seriesLength:]
+	}
+
+	var rules []int
+	for rule := RuleBeyondLimits; rule <= RuleMixture; rule++ {
+		violated := evaluate(rule, s.sigmas)
+		if violated && (rule == RuleBeyondLimits || !s.active[rule]) {
+			rules = append(rules, rule)
+		}
+		s.active[rule] = violated
+	}
+	return rules
+}
+
+// evaluate 判断序列是否违反规则，序列最后一个值为最新的检测值
+func evaluate(rule int, sigmas []float64) bool {
+	n := len(sigmas)
+	last := sigmas[n-1]
+	switch rule {
+	case RuleBeyondLimits:
+		return math.Abs(last) > 3
+	case RuleRunOneSide:
+		return n >= 7 && allOf(sigmas[n-7:], func(v float64) bool { return v > 0 }) ||
+			n >= 7 && allOf(sigmas[n-7:], func(v float64) bool { return v < 0 })
+	case RuleTrend:
+		if n < 6 {
+			return false
+		}
+		var up, down = true, true
+		for i := n - 5; i < n; i++ {
+			up = up && sigmas[i] > sigmas[i-1]
+			down = down && sigmas[i] < sigmas[i-1]
+		}
+		return up || down
+	case RuleAlternating:
+		if n < 14 {
+			return false
+		}
+		for i := n - 12; i < n; i++ {
+			if (sigmas[i]-sigmas[i-1])*(sigmas[i-1]-sigmas[i-2]) >= 0 {
+				return false
+			}
+		}
+		return true
+	case RuleTwoOfThree:
+		return n >= 3 && (last > 2 && countOf(sigmas[n-3:], func(v float64) bool { return v > 2 }) >= 2 ||
+			last < -2 && countOf(sigmas[n-3:], func(v float64) bool { return v < -2 }) >= 2)
+	case RuleFourOfFive:
+		return n >= 5 && (last > 1 && countOf(sigmas[n-5:], func(v float64) bool { return v > 1 }) >= 4 ||
+			last < -1 && countOf(sigmas[n-5:], func(v float64) bool { return v < -1 }) >= 4)
+	case RuleStratification:
+		return n >= 15 && allOf(sigmas[n-15:], func(v float64) bool { return math.Abs(v) < 1 })
+	case RuleMixture:
+		return n >= 8 && allOf(sigmas[n-8:], func(v float64) bool { return math.Abs(v) > 1 }) &&
+			countOf(sigmas[n-8:], func(v float64) bool { return v > 0 }) > 0 &&
+			countOf(sigmas[n-8:], func(v float64) bool { return v < 0 }) > 0
+	}
+	return false
+}
+
+func allOf(values []float64, fn func(v float64) bool) bool {
+	return countOf(values, fn) == len(values)
+}
+
+func countOf(values []float64, fn func(v float64) bool) int {
+	var count int
+	for _, v := range values {
+		if fn(v) {
+			count++
+		}
+	}
+	return count
+}
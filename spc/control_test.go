@@ -0,0 +1,64 @@
+package spc
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+func TestControlChartDerivedLimits(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 30, 0, 0, time.Local)
+	openTestDB(t, now)
+
+	engine := NewEngine(0)
+	values := []float64{1.0, 1.2, 0.9, 1.1, 1.0, 1.3}
+	for i, v := range values {
+		engine.AfterProduce(testProduct(1, 1, now.Add(time.Duration(i)*time.Second), v), nil)
+	}
+	cc := NewControlChart(engine, len(values))
+
+	// 刷新控制限时需要写入并查询统计量，刷新期间不阻塞判异
+	engine.flushMu.Lock()
+	done := make(chan bool)
+	go func() {
+		_, ok := cc.limits(1, "P1", nil)
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("limits derived before refresh finished")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("limits blocked by engine flush")
+	}
+	engine.flushMu.Unlock()
+	cc.Stop()
+
+	limits, ok := cc.limits(1, "P1", nil)
+	if !ok {
+		t.Fatal("limits not derived after refresh")
+	}
+	mean, _, sigmaWithin := expectedStatistics(values)
+	if math.Abs(limits.CenterLine-mean) > 1e-9 || math.Abs(limits.UCL-(mean+3*sigmaWithin)) > 1e-9 || math.Abs(limits.LCL-(mean-3*sigmaWithin)) > 1e-9 {
+		t.Errorf("limits = %+v, want center %v sigma within %v", limits, mean, sigmaWithin)
+	}
+
+	// 样本不足时不推算控制限
+	if _, ok := cc.limits(2, "P1", nil); ok {
+		t.Error("limits derived for version without samples")
+	}
+	cc.Stop()
+	if _, ok := cc.limits(2, "P1", nil); ok {
+		t.Error("limits derived for version without samples")
+	}
+
+	// 配置了控制限的点位不需要推算
+	ucl, lcl := 2.0, 0.0
+	limits, ok = cc.limits(3, "P1", &orm.PointSpec{UCL: &ucl, LCL: &lcl})
+	if !ok || limits.CenterLine != 1 {
+		t.Errorf("configured limits = %+v, %v, want center 1", limits, ok)
+	}
+}
@@ -0,0 +1,216 @@
+package spc
+
+import (
+	"github.com/SasukeBo/log"
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WindowSize 统计窗口大小，查询时按窗口合并
+const WindowSize = time.Hour
+
+type windowKey struct {
+	versionID   uint
+	deviceID    uint
+	pointName   string
+	windowStart int64
+}
+
+// window 统计窗口自上次写入后的增量
+// last 为本实例在窗口内的最后一个检测值，写入后保留，用于计算与下一个检测值之间的移动极差
+type window struct {
+	delta   orm.SPCStat
+	last    float64
+	hasLast bool
+}
+
+// add 将检测值累加到窗口的增量
+func (w *window) add(value float64, spec *orm.PointSpec, buckets int) {
+	if w.delta.Count == 0 && w.hasLast {
+		w.delta.MovingRangeSum += math.Abs(value - w.last)
+		w.delta.MovingRangeCount++
+	}
+	add(&w.delta, value, spec, buckets)
+	w.last, w.hasLast = value, true
+}
+
+// windowStartOf 检测时间所在统计窗口的开始时间
+// 按工厂时区的整点划分，时区偏移不是整小时时窗口仍与当地整点对齐
+func windowStartOf(t time.Time) time.Time {
+	_, offset := t.In(clock.Location()).Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(WindowSize).Add(-shift)
+}
+
+// Engine SPC统计引擎
+// 产品写入后在内存中累加当前窗口自上次写入后的增量，不读取数据库，
+// 定时将增量合并到 spc_stats 表，多个实例处理同一料号版本时各自合并增量，
+// 查询时合并时间范围内的窗口，不需要扫描产品表
+type Engine struct {
+	buckets int // 直方图区间数量
+
+	mu      sync.Mutex
+	windows map[windowKey]*window
+
+	flushMu sync.Mutex // 定时任务、查询及控制图可能同时写入，串行执行避免同一增量重复合并
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewEngine(buckets int) *Engine {
+	return &Engine{
+		buckets: buckets,
+		windows: make(map[windowKey]*window),
+	}
+}
+
+// AfterProduce 累加产品的点位检测值，缺失或非法的点位不参与统计
+func (e *Engine) AfterProduce(product *orm.Product, specs []orm.PointSpec) {
+	var specMap = make(map[string]*orm.PointSpec)
+	for i := range specs {
+		specMap[specs[i].Name] = &specs[i]
+	}
+
+	windowStart := windowStartOf(product.CreatedAt)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name, v := range product.PointValues {
+		value, ok := v.(float64)
+		if !ok {
+			continue
+		}
+
+		key := windowKey{
+			versionID:   product.MaterialVersionID,
+			deviceID:    product.DeviceID,
+			pointName:   name,
+			windowStart: windowStart.Unix(),
+		}
+		w, ok := e.windows[key]
+		if !ok {
+			w = &window{}
+			e.windows[key] = w
+		}
+		w.add(value, specMap[name], e.buckets)
+	}
+}
+
+// Flush 将统计窗口的增量合并到数据库，并释放已结束且无增量的窗口
+// 合并失败的增量保留在内存中，下次写入时重试
+func (e *Engine) Flush() error {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	e.mu.Lock()
+	var keys []windowKey
+	var deltas []orm.SPCStat
+	for key, w := range e.windows {
+		if w.delta.Count == 0 {
+			continue
+		}
+		delta := w.delta
+		delta.MaterialVersionID = key.versionID
+		delta.DeviceID = key.deviceID
+		delta.PointName = key.pointName
+		delta.WindowStart = time.Unix(key.windowStart, 0)
+		keys = append(keys, key)
+		deltas = append(deltas, delta)
+		w.delta = orm.SPCStat{}
+	}
+	e.mu.Unlock()
+
+	var lastErr error
+	for i := range deltas {
+		if err := orm.AccumulateSPCStat(&deltas[i], merge); err != nil {
+			log.Error("%v", err)
+			lastErr = err
+			e.mu.Lock()
+			w := e.windows[keys[i]]
+			merge(&deltas[i], &w.delta)
+			w.delta = deltas[i]
+			e.mu.Unlock()
+		}
+	}
+
+	expired := clock.Now().Add(-2 * WindowSize).Unix()
+	e.mu.Lock()
+	for key, w := range e.windows {
+		if key.windowStart < expired && w.delta.Count == 0 {
+			delete(e.windows, key)
+		}
+	}
+	e.mu.Unlock()
+	return lastErr
+}
+
+// Start 定时写入统计窗口
+func (e *Engine) Start(interval time.Duration) {
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = e.Flush()
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止定时任务，并写入剩余的统计窗口
+func (e *Engine) Stop() {
+	if e.stop != nil {
+		close(e.stop)
+		<-e.done
+	}
+	_ = e.Flush()
+}
+
+// Query 查询料号版本各点位的统计结果
+func (e *Engine) Query(q orm.SPCStatQuery) ([]Statistics, error) {
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	stats, err := orm.FindSPCStats(q)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := orm.GetPointSpecsWithVersionID(q.MaterialVersionID)
+	if err != nil {
+		return nil, err
+	}
+	var specMap = make(map[string]*orm.PointSpec)
+	for i := range specs {
+		specMap[specs[i].Name] = &specs[i]
+	}
+
+	var summaries = make(map[string]*summary)
+	var names []string
+	for i := range stats {
+		name := stats[i].PointName
+		s, ok := summaries[name]
+		if !ok {
+			s = &summary{}
+			summaries[name] = s
+			names = append(names, name)
+		}
+		s.merge(&stats[i])
+	}
+
+	sort.Strings(names)
+	var results = make([]Statistics, 0, len(names))
+	for _, name := range names {
+		results = append(results, summaries[name].statistics(name, specMap[name]))
+	}
+	return results, nil
+}
@@ -0,0 +1,204 @@
+package spc
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+// expectedStatistics 直接由全部检测值计算的统计指标，检测值按时间顺序排列
+func expectedStatistics(values []float64) (mean, sigma, sigmaWithin float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	var ss, mr float64
+	for i, v := range values {
+		ss += (v - mean) * (v - mean)
+		if i > 0 {
+			mr += math.Abs(v - values[i-1])
+		}
+	}
+	sigma = math.Sqrt(ss / float64(len(values)-1))
+	sigmaWithin = mr / float64(len(values)-1) / d2
+	return mean, sigma, sigmaWithin
+}
+
+func assertStatistics(t *testing.T, result Statistics, values []float64) {
+	t.Helper()
+	mean, sigma, sigmaWithin := expectedStatistics(values)
+	if result.Count != len(values) {
+		t.Fatalf("count = %v, want %v", result.Count, len(values))
+	}
+	if math.Abs(result.Mean-mean) > 1e-9 {
+		t.Errorf("mean = %v, want %v", result.Mean, mean)
+	}
+	if result.Sigma == nil || math.Abs(*result.Sigma-sigma) > 1e-9 {
+		t.Errorf("sigma = %v, want %v", result.Sigma, sigma)
+	}
+	if result.SigmaWithin == nil || math.Abs(*result.SigmaWithin-sigmaWithin) > 1e-9 {
+		t.Errorf("sigma within = %v, want %v", result.SigmaWithin, sigmaWithin)
+	}
+}
+
+func countSPCStats(t *testing.T) int {
+	t.Helper()
+	var count int
+	if err := orm.DB.Model(&orm.SPCStat{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestEngineAccumulate(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 30, 0, 0, time.Local)
+	openTestDB(t, now)
+
+	usl, lsl := 2.0, 1.0
+	specs := []orm.PointSpec{{MaterialVersionID: 1, Name: "P1", USL: &usl, LSL: &lsl}}
+	values := []float64{1.2, 1.5, 1.1, 1.8, 1.4, 1.6, 0.9}
+	engine := NewEngine(4)
+	for i, v := range values {
+		engine.AfterProduce(testProduct(1, 1, now.Add(time.Duration(i)*time.Minute), v), specs)
+		// 同一窗口分多次写入，每次只合并增量
+		if i == 2 || i == 4 {
+			if err := engine.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countSPCStats(t); n != 1 {
+		t.Fatalf("spc_stats rows = %v, want 1", n)
+	}
+	var stat orm.SPCStat
+	if err := orm.DB.First(&stat).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stat.Min != 0.9 || stat.Max != 1.8 || stat.LastValue != 0.9 {
+		t.Errorf("min/max/last = %v/%v/%v, want 0.9/1.8/0.9", stat.Min, stat.Max, stat.LastValue)
+	}
+	// 低于下限 1 个，[1,1.25) 2 个，[1.25,1.5) 1 个，[1.5,1.75) 2 个，[1.75,2] 1 个
+	want := []int{1, 2, 1, 2, 1, 0}
+	for i, c := range want {
+		if stat.Histogram.Counts[i] != c {
+			t.Fatalf("histogram = %v, want %v", stat.Histogram.Counts, want)
+		}
+	}
+
+	results, err := engine.Query(orm.SPCStatQuery{MaterialVersionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %v results, want 1", len(results))
+	}
+	assertStatistics(t, results[0], values)
+}
+
+func TestEngineMultipleInstances(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 30, 0, 0, time.Local)
+	openTestDB(t, now)
+
+	// 两个实例交替处理同一窗口，合并后的计数及均值包含两者的全部检测值
+	first, second := NewEngine(0), NewEngine(0)
+	values := []float64{1, 2, 3, 4, 5, 6}
+	for i, v := range values {
+		engine := first
+		if i%2 == 1 {
+			engine = second
+		}
+		engine.AfterProduce(testProduct(1, 1, now.Add(time.Duration(i)*time.Second), v), nil)
+		if err := engine.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := first.Query(orm.SPCStatQuery{MaterialVersionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Count != len(values) || results[0].Mean != 3.5 {
+		t.Fatalf("results = %+v, want count 6 mean 3.5", results)
+	}
+	if results[0].Min != 1 || results[0].Max != 6 {
+		t.Errorf("min/max = %v/%v, want 1/6", results[0].Min, results[0].Max)
+	}
+}
+
+func TestEngineWindowRollover(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 59, 0, 0, time.Local)
+	openTestDB(t, now)
+
+	engine := NewEngine(0)
+	engine.AfterProduce(testProduct(1, 1, now, 1), nil)
+	engine.AfterProduce(testProduct(1, 1, now.Add(30*time.Second), 2), nil)
+	engine.AfterProduce(testProduct(1, 1, now.Add(time.Minute), 3), nil)
+	if err := engine.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := orm.FindSPCStats(orm.SPCStatQuery{MaterialVersionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %v windows, want 2", len(stats))
+	}
+	if stats[0].Count != 2 || stats[1].Count != 1 {
+		t.Errorf("window counts = %v/%v, want 2/1", stats[0].Count, stats[1].Count)
+	}
+	if !stats[1].WindowStart.Equal(now.Add(time.Minute)) {
+		t.Errorf("second window starts at %v, want %v", stats[1].WindowStart, now.Add(time.Minute))
+	}
+
+	// 已结束的窗口写入后释放，之后的数据仍合并到数据库中的窗口
+	clock.Set(clock.NewFixedClock(now.Add(3 * time.Hour)))
+	if err := engine.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(engine.windows) != 0 {
+		t.Fatalf("%v windows remain in memory, want 0", len(engine.windows))
+	}
+	engine.AfterProduce(testProduct(1, 1, now, 4), nil)
+	results, err := engine.Query(orm.SPCStatQuery{MaterialVersionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Count != 4 {
+		t.Fatalf("results = %+v, want count 4", results)
+	}
+}
+
+func TestWindowStartOf(t *testing.T) {
+	defer func() { _ = clock.SetTimezone("") }()
+
+	var cases = []struct {
+		timezone string
+		time     string
+		want     string
+	}{
+		{"Asia/Shanghai", "2026-03-02T10:45:00+08:00", "2026-03-02T10:00:00+08:00"},
+		{"Asia/Kolkata", "2026-03-02T10:45:00+05:30", "2026-03-02T10:00:00+05:30"},
+		{"Asia/Kolkata", "2026-03-02T10:15:00+05:30", "2026-03-02T10:00:00+05:30"},
+		{"Asia/Kathmandu", "2026-03-02T00:10:00+05:45", "2026-03-02T00:00:00+05:45"},
+		// 检测时间的时区与工厂时区不同时按工厂时区划分
+		{"Asia/Kolkata", "2026-03-02T05:00:00Z", "2026-03-02T10:00:00+05:30"},
+	}
+	for _, c := range cases {
+		if err := clock.SetTimezone(c.timezone); err != nil {
+			t.Fatal(err)
+		}
+		at, _ := time.Parse(time.RFC3339, c.time)
+		want, _ := time.Parse(time.RFC3339, c.want)
+		if got := windowStartOf(at); !got.Equal(want) {
+			t.Errorf("%s: windowStartOf(%s) = %v, want %s", c.timezone, c.time, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package spc
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SasukeBo/pmes-data-producer/clock"
+	"github.com/SasukeBo/pmes-data-producer/orm"
+)
+
+// openTestDB 在临时目录中创建 SQLite 数据库并执行全部迁移，时钟固定为 now
+func openTestDB(t *testing.T, now time.Time) {
+	t.Helper()
+	clock.Set(clock.NewFixedClock(now))
+	t.Cleanup(func() { clock.Set(nil) })
+
+	if _, err := orm.Open(orm.Options{Driver: orm.DialectSQLite, Name: filepath.Join(t.TempDir(), "test.db")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = orm.Close() })
+	if _, err := orm.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testProduct 构造只有一个点位检测值的产品
+func testProduct(versionID, deviceID uint, createdAt time.Time, value float64) *orm.Product {
+	return &orm.Product{
+		MaterialVersionID: versionID,
+		DeviceID:          deviceID,
+		CreatedAt:         createdAt,
+		PointValues:       orm.Map{"P1": value},
+	}
+}
@@ -0,0 +1,222 @@
+package spc
+
+import (
+	"github.com/SasukeBo/pmes-data-producer/orm"
+	"math"
+)
+
+// d2 子组大小为2时的控制图常数，用于由平均移动极差估计组内标准差
+const d2 = 1.128
+
+// Bucket 直方图区间，Lower 为空表示低于规格下限，Upper 为空表示高于规格上限
+type Bucket struct {
+	Lower *float64 `json:"lower"`
+	Upper *float64 `json:"upper"`
+	Count int      `json:"count"`
+}
+
+// Statistics 点位检测值的统计结果，无法计算的指标为空
+type Statistics struct {
+	PointName   string   `json:"point_name"`
+	Count       int      `json:"count"`
+	Mean        float64  `json:"mean"`
+	Min         float64  `json:"min"`
+	Max         float64  `json:"max"`
+	Sigma       *float64 `json:"sigma"`        // 整体标准差
+	SigmaWithin *float64 `json:"sigma_within"` // 组内标准差，MR/d2
+	USL         *float64 `json:"usl"`
+	LSL         *float64 `json:"lsl"`
+	Cp          *float64 `json:"cp"`
+	Cpk         *float64 `json:"cpk"`
+	Pp          *float64 `json:"pp"`
+	Ppk         *float64 `json:"ppk"`
+	Histogram   []Bucket `json:"histogram"`
+}
+
+// add 将检测值累加到统计窗口
+// 存在双侧规格时按规格范围累加直方图，规格变更后直方图重新计数
+func add(stat *orm.SPCStat, value float64, spec *orm.PointSpec, buckets int) {
+	if stat.Count == 0 {
+		stat.Min = value
+		stat.Max = value
+	} else {
+		stat.MovingRangeSum += math.Abs(value - stat.LastValue)
+		stat.MovingRangeCount++
+		stat.Min = math.Min(stat.Min, value)
+		stat.Max = math.Max(stat.Max, value)
+	}
+	stat.Count++
+	delta := value - stat.Mean
+	stat.Mean += delta / float64(stat.Count)
+	stat.M2 += delta * (value - stat.Mean)
+	stat.LastValue = value
+
+	if spec == nil || spec.USL == nil || spec.LSL == nil || *spec.USL <= *spec.LSL || buckets <= 0 {
+		return
+	}
+	hist := &stat.Histogram
+	if hist.Lower != *spec.LSL || hist.Upper != *spec.USL || len(hist.Counts) != buckets+2 {
+		*hist = orm.Histogram{Lower: *spec.LSL, Upper: *spec.USL, Counts: make([]int, buckets+2)}
+	}
+	hist.Counts[bucketIndex(value, hist.Lower, hist.Upper, buckets)]++
+}
+
+// merge 将统计窗口的增量合并到累加量，均值及离差平方和使用并行算法合并
+// 增量的直方图与累加量的规格不同时，规格变更前的直方图不再合并
+func merge(stat, delta *orm.SPCStat) {
+	if delta.Count == 0 {
+		return
+	}
+	if stat.Count == 0 {
+		stat.Min = delta.Min
+		stat.Max = delta.Max
+	} else {
+		stat.Min = math.Min(stat.Min, delta.Min)
+		stat.Max = math.Max(stat.Max, delta.Max)
+	}
+
+	total := stat.Count + delta.Count
+	diff := delta.Mean - stat.Mean
+	stat.M2 = stat.M2 + delta.M2 + diff*diff*float64(stat.Count)*float64(delta.Count)/float64(total)
+	stat.Mean = stat.Mean + diff*float64(delta.Count)/float64(total)
+	stat.Count = total
+	stat.MovingRangeSum += delta.MovingRangeSum
+	stat.MovingRangeCount += delta.MovingRangeCount
+	stat.LastValue = delta.LastValue
+
+	hist := delta.Histogram
+	if len(hist.Counts) == 0 {
+		return
+	}
+	if stat.Histogram.Lower != hist.Lower || stat.Histogram.Upper != hist.Upper || len(stat.Histogram.Counts) != len(hist.Counts) {
+		stat.Histogram = orm.Histogram{Lower: hist.Lower, Upper: hist.Upper, Counts: make([]int, len(hist.Counts))}
+	}
+	for i, c := range hist.Counts {
+		stat.Histogram.Counts[i] += c
+	}
+}
+
+func bucketIndex(value, lower, upper float64, buckets int) int {
+	if value < lower {
+		return 0
+	}
+	if value > upper {
+		return buckets + 1
+	}
+	idx := int((value-lower)/(upper-lower)*float64(buckets)) + 1
+	if idx > buckets {
+		idx = buckets
+	}
+	return idx
+}
+
+// summary 多个统计窗口合并后的累加量
+type summary struct {
+	count            int
+	mean             float64
+	m2               float64
+	min              float64
+	max              float64
+	movingRangeSum   float64
+	movingRangeCount int
+	histogram        *orm.Histogram
+}
+
+// merge 合并统计窗口，均值及离差平方和使用并行算法合并
+func (s *summary) merge(stat *orm.SPCStat) {
+	if stat.Count == 0 {
+		return
+	}
+	if s.count == 0 {
+		s.min = stat.Min
+		s.max = stat.Max
+	} else {
+		s.min = math.Min(s.min, stat.Min)
+		s.max = math.Max(s.max, stat.Max)
+	}
+
+	total := s.count + stat.Count
+	delta := stat.Mean - s.mean
+	s.m2 = s.m2 + stat.M2 + delta*delta*float64(s.count)*float64(stat.Count)/float64(total)
+	s.mean = s.mean + delta*float64(stat.Count)/float64(total)
+	s.count = total
+	s.movingRangeSum += stat.MovingRangeSum
+	s.movingRangeCount += stat.MovingRangeCount
+
+	hist := stat.Histogram
+	if len(hist.Counts) == 0 {
+		return
+	}
+	if s.histogram == nil || s.histogram.Lower != hist.Lower || s.histogram.Upper != hist.Upper || len(s.histogram.Counts) != len(hist.Counts) {
+		// 规格变更前的直方图不再合并
+		s.histogram = &orm.Histogram{Lower: hist.Lower, Upper: hist.Upper, Counts: make([]int, len(hist.Counts))}
+	}
+	for i, c := range hist.Counts {
+		s.histogram.Counts[i] += c
+	}
+}
+
+// statistics 由合并后的累加量计算统计指标
+func (s *summary) statistics(pointName string, spec *orm.PointSpec) Statistics {
+	var result = Statistics{
+		PointName: pointName,
+		Count:     s.count,
+		Mean:      s.mean,
+		Min:       s.min,
+		Max:       s.max,
+	}
+	if spec != nil {
+		result.USL = spec.USL
+		result.LSL = spec.LSL
+	}
+	if s.count > 1 {
+		sigma := math.Sqrt(s.m2 / float64(s.count-1))
+		result.Sigma = &sigma
+		result.Pp, result.Ppk = capability(s.mean, sigma, result.USL, result.LSL)
+	}
+	if s.movingRangeCount > 0 {
+		sigmaWithin := s.movingRangeSum / float64(s.movingRangeCount) / d2
+		result.SigmaWithin = &sigmaWithin
+		result.Cp, result.Cpk = capability(s.mean, sigmaWithin, result.USL, result.LSL)
+	}
+
+	if s.histogram != nil {
+		hist := s.histogram
+		buckets := len(hist.Counts) - 2
+		width := (hist.Upper - hist.Lower) / float64(buckets)
+		for i, c := range hist.Counts {
+			var bucket = Bucket{Count: c}
+			if i > 0 {
+				lower := hist.Lower + float64(i-1)*width
+				bucket.Lower = &lower
+			}
+			if i <= buckets {
+				upper := hist.Lower + float64(i)*width
+				bucket.Upper = &upper
+			}
+			result.Histogram = append(result.Histogram, bucket)
+		}
+	}
+	return result
+}
+
+// capability 计算过程能力指数，单侧规格时只计算 Cpk/Ppk
+func capability(mean, sigma float64, usl, lsl *float64) (cp, cpk *float64) {
+	if sigma <= 0 || (usl == nil && lsl == nil) {
+		return nil, nil
+	}
+	if usl != nil && lsl != nil {
+		p := (*usl - *lsl) / (6 * sigma)
+		cp = &p
+	}
+
+	var k = math.Inf(1)
+	if usl != nil {
+		k = math.Min(k, (*usl-mean)/(3*sigma))
+	}
+	if lsl != nil {
+		k = math.Min(k, (mean-*lsl)/(3*sigma))
+	}
+	cpk = &k
+	return cp, cpk
+}